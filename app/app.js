@@ -175,7 +175,9 @@ async function uploadVideoFile(videoID) {
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log('Video uploaded!');
+    const job = await res.json();
+    console.log('Video uploaded! Processing...');
+    await waitForJob(job.id);
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -184,11 +186,9 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
-const videoStateHandler = createVideoStateHandler();
-
-async function getVideos() {
-  try {
-    const res = await fetch('/api/videos', {
+async function waitForJob(jobID) {
+  for (;;) {
+    const res = await fetch(`/api/jobs/${jobID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -196,10 +196,45 @@ async function getVideos() {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
+      throw new Error(`Failed to get processing status. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const job = await res.json();
+    if (job.status === 'succeeded') {
+      return job;
+    }
+    if (job.status === 'failed') {
+      throw new Error(`Video processing failed. Error: ${job.last_error}`);
+    }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
+const videoStateHandler = createVideoStateHandler();
+
+async function getVideos() {
+  try {
+    const videos = [];
+    let cursor = null;
+    do {
+      const params = new URLSearchParams({ limit: '100' });
+      if (cursor) {
+        params.set('cursor', cursor);
+      }
+      const res = await fetch(`/api/videos?${params}`, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+      videos.push(...data.videos);
+      cursor = data.next_cursor;
+    } while (cursor);
+
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -250,11 +285,24 @@ async function getVideo(videoID) {
 
 let currentVideo = null;
 
+function formatDuration(seconds) {
+  const total = Math.round(seconds);
+  const mins = Math.floor(total / 60);
+  const secs = String(total % 60).padStart(2, '0');
+  return `${mins}:${secs}`;
+}
+
 function viewVideo(video) {
   currentVideo = video;
   document.getElementById('video-display').style.display = 'block';
   document.getElementById('video-title-display').textContent = video.title;
   document.getElementById('video-description-display').textContent = video.description;
+  document.getElementById('video-status-display').textContent = video.failure_reason
+    ? `${video.status} (${video.failure_reason})`
+    : video.status;
+  document.getElementById('video-duration-display').textContent = video.media_info
+    ? formatDuration(video.media_info.duration)
+    : '';
 
   const thumbnailImg = document.getElementById('thumbnail-image');
   if (!video.thumbnail_url) {
@@ -271,7 +319,9 @@ function viewVideo(video) {
       videoPlayer.style.display = 'none';
     } else {
       videoPlayer.style.display = 'block';
-      videoPlayer.src = video.video_url;
+      // Browsers with native HLS (Safari, iOS) get the adaptive stream
+      const nativeHLS = videoPlayer.canPlayType('application/vnd.apple.mpegurl') !== '';
+      videoPlayer.src = video.manifest_url && nativeHLS ? video.manifest_url : video.video_url;
       videoPlayer.load();
     }
   }
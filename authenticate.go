@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type principalKind int
+
+const (
+	principalAnonymous principalKind = iota
+	principalLogin
+	principalAPIKey
+)
+
+// principal is who is making a request, as worked out by withAuth.
+type principal struct {
+	Kind   principalKind
+	UserID uuid.UUID
+	// APIKey is the key used, for principalAPIKey.
+	APIKey *database.APIKey
+}
+
+type principalContextKey struct{}
+
+// principalFrom returns the caller of a request served through withAuth, or
+// an anonymous principal.
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalContextKey{}).(principal)
+	return p
+}
+
+// authPolicy declares what a route requires of its caller.
+type authPolicy struct {
+	// anonymous lets requests without credentials through.
+	anonymous bool
+	// loginOnly turns API keys away.
+	loginOnly bool
+	// scopes are the scopes of which an API key needs any.
+	scopes []database.APIKeyScope
+}
+
+var (
+	// authLogin is for what only a logged in user may do, such as managing
+	// API keys and sessions.
+	authLogin  = authPolicy{loginOnly: true}
+	authRead   = authPolicy{scopes: []database.APIKeyScope{database.APIKeyScopeRead}}
+	authUpload = authPolicy{scopes: []database.APIKeyScope{database.APIKeyScopeUpload}}
+	authDelete = authPolicy{scopes: []database.APIKeyScope{database.APIKeyScopeDelete}}
+	// authReadOrUpload is for jobs, which uploaders poll.
+	authReadOrUpload = authPolicy{scopes: []database.APIKeyScope{database.APIKeyScopeRead, database.APIKeyScopeUpload}}
+)
+
+// withAuth serves a route with the given policy. It identifies the caller
+// from either an access token ("Authorization: Bearer ...") or an API key
+// ("Authorization: ApiKey ..."), responds itself if the policy doesn't allow
+// the request and otherwise passes the caller on in the request context.
+func (cfg *apiConfig) withAuth(policy authPolicy, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := cfg.resolvePrincipal(w, r, policy)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, caller)))
+	})
+}
+
+func (cfg *apiConfig) resolvePrincipal(w http.ResponseWriter, r *http.Request, policy authPolicy) (principal, bool) {
+	header := r.Header.Get("Authorization")
+	switch {
+	case header == "":
+		if policy.anonymous {
+			return principal{Kind: principalAnonymous}, true
+		}
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", auth.ErrNoAuthHeaderIncluded)
+		return principal{}, false
+	case strings.HasPrefix(header, "ApiKey "):
+		if policy.loginOnly {
+			respondWithError(w, http.StatusForbidden, "API keys can't be used here, log in instead", nil)
+			return principal{}, false
+		}
+		return cfg.resolveAPIKey(w, r, policy.scopes)
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return principal{}, false
+	}
+	userID, sessionID, err := cfg.jwtKeys.validate(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return principal{}, false
+	}
+	// the token is only as good as the session it was issued for, which
+	// ends when its refresh tokens are revoked. Tokens issued before they
+	// named their session are accepted until they expire, at most
+	// loginAccessTokenTTL after the upgrade, rather than logging everyone
+	// out at once.
+	if sessionID == "" {
+		return principal{Kind: principalLogin, UserID: userID}, true
+	}
+	active, err := cfg.db.SessionActive(userID, sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check session", err)
+		return principal{}, false
+	}
+	if !active {
+		respondWithError(w, http.StatusUnauthorized, "Session has ended", nil)
+		return principal{}, false
+	}
+	return principal{Kind: principalLogin, UserID: userID}, true
+}
+
+func (cfg *apiConfig) resolveAPIKey(w http.ResponseWriter, r *http.Request, scopes []database.APIKeyScope) (principal, bool) {
+	secret, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return principal{}, false
+	}
+	key, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(secret))
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+		return principal{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return principal{}, false
+	}
+	now := time.Now()
+	if key.RevokedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "API key was revoked", nil)
+		return principal{}, false
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "API key expired", nil)
+		return principal{}, false
+	}
+	if !key.Scopes.Has(scopes...) {
+		respondWithError(w, http.StatusForbidden, "API key lacks the scope for this request", nil)
+		return principal{}, false
+	}
+
+	if err := cfg.db.TouchAPIKey(key.ID, now); err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", key.ID, err)
+	}
+	return principal{Kind: principalAPIKey, UserID: key.UserID, APIKey: &key}, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestWithAuthEndedSession(t *testing.T) {
+	cfg, video := newUploadTestConfig(t)
+	cfg.jwtKeys = &jwtKeys{db: cfg.db, algorithm: auth.AlgorithmHS256, secret: "secret"}
+	if err := cfg.jwtKeys.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := cfg.db.CreateSession(database.CreateSessionParams{
+		UserID:                video.UserID,
+		RefreshToken:          uuid.NewString(),
+		RefreshTokenExpiresAt: time.Now().UTC().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := cfg.withAuth(authRead, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	call := func(sessionID string) int {
+		t.Helper()
+		token, err := auth.MakeJWT(video.UserID, sessionID, cfg.jwtKeys.keySet(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := call(session.ID); code != http.StatusNoContent {
+		t.Fatalf("token of an active session = %d, want 204", code)
+	}
+	if code := call(""); code != http.StatusNoContent {
+		t.Errorf("token issued before tokens named their session = %d, want 204", code)
+	}
+	if err := cfg.db.RevokeSession(video.UserID, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(session.ID); code != http.StatusUnauthorized {
+		t.Errorf("token of a revoked session = %d, want 401", code)
+	}
+}
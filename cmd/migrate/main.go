@@ -0,0 +1,76 @@
+// Command migrate applies, rolls back and lists the database migrations.
+//
+//	go run ./cmd/migrate up      apply every pending migration
+//	go run ./cmd/migrate down    roll back the latest migration
+//	go run ./cmd/migrate status  list migrations and when they were applied
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load(".env")
+
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up|down|status")
+		os.Exit(2)
+	}
+
+	// DB_PATH is the older name, from when only SQLite was supported
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
+		log.Fatal("DB_URL must be set")
+	}
+	db, err := database.OpenClient(dbURL)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "up":
+		if err := db.Migrate(database.MigrateOptions{}); err != nil {
+			log.Fatalf("Couldn't migrate: %v", err)
+		}
+		printStatus(db)
+	case "down":
+		m, err := db.Rollback()
+		if errors.Is(err, database.ErrNoMigrationToRollBack) {
+			fmt.Println("Nothing to roll back")
+			return
+		}
+		if err != nil {
+			log.Fatalf("Couldn't roll back: %v", err)
+		}
+		fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+	case "status":
+		printStatus(db)
+	default:
+		fmt.Fprintln(os.Stderr, "usage: migrate up|down|status")
+		os.Exit(2)
+	}
+}
+
+func printStatus(db database.Client) {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		log.Fatalf("Couldn't get migration status: %v", err)
+	}
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// The garbage collector removes stored objects that no video references,
+// such as the previous thumbnail after a new one is uploaded. Objects younger
+// than cfg.gcGracePeriod are left alone so uploads that haven't been saved to
+// their video yet survive.
+
+type gcReport struct {
+	DryRun       bool  `json:"dry_run"`
+	Scanned      int   `json:"scanned"`
+	Unreferenced int   `json:"unreferenced"`
+	Deleted      int   `json:"deleted"`
+	Bytes        int64 `json:"bytes"`
+}
+
+// collectGarbage runs one pass over both stores. In dry-run mode the
+// unreferenced objects are only logged.
+func (cfg *apiConfig) collectGarbage(ctx context.Context) (gcReport, error) {
+	report := gcReport{DryRun: cfg.gcDryRun}
+
+	// list before reading the videos, so an object saved to a video in
+	// between is never seen as unreferenced
+	stores := []storage.BlobStore{cfg.videoStore}
+	if cfg.assetStore.URL("") != cfg.videoStore.URL("") {
+		stores = append(stores, cfg.assetStore)
+	}
+	listings := make([][]storage.ObjectInfo, len(stores))
+	for i, store := range stores {
+		objects, err := store.List(ctx, "")
+		if err != nil {
+			return report, err
+		}
+		listings[i] = objects
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return report, err
+	}
+	referenced := map[string]bool{}
+	var referencedPrefixes []string
+	for _, video := range videos {
+		// keys are random, so they're compared without their store
+		objects := videoObjects(video)
+		for _, ref := range objects.Objects {
+			referenced[ref.Key] = true
+		}
+		for _, ref := range objects.Prefixes {
+			referencedPrefixes = append(referencedPrefixes, ref.Key)
+		}
+	}
+	isReferenced := func(key string) bool {
+		if referenced[key] {
+			return true
+		}
+		for _, prefix := range referencedPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cutoff := time.Now().Add(-cfg.gcGracePeriod)
+	for i, store := range stores {
+		for _, object := range listings[i] {
+			report.Scanned++
+			if isReferenced(object.Key) || object.LastModified.After(cutoff) {
+				continue
+			}
+			// the snapshot above may be outdated by now, e.g. by an upload
+			// saved to its video meanwhile
+			referenced, err := cfg.db.ObjectKeyReferenced(object.Key)
+			if err != nil {
+				log.Printf("GC: couldn't check references to %s: %v", store.URL(object.Key), err)
+				continue
+			}
+			if referenced {
+				continue
+			}
+			report.Unreferenced++
+			report.Bytes += object.Size
+
+			if cfg.gcDryRun {
+				log.Printf("GC: would delete unreferenced object %s (%d bytes, modified %s)", store.URL(object.Key), object.Size, object.LastModified.Format(time.RFC3339))
+				continue
+			}
+			err = store.Delete(ctx, object.Key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("GC: couldn't delete %s: %v", store.URL(object.Key), err)
+				continue
+			}
+			report.Deleted++
+		}
+	}
+	return report, nil
+}
+
+// startGarbageCollector runs collectGarbage every cfg.gcInterval until ctx
+// is cancelled.
+func (cfg *apiConfig) startGarbageCollector(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(cfg.gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			report, err := cfg.collectGarbage(ctx)
+			if err != nil {
+				log.Printf("GC failed: %v", err)
+				continue
+			}
+			log.Printf("GC: scanned %d objects, %d unreferenced (%d bytes), deleted %d, dry run %t",
+				report.Scanned, report.Unreferenced, report.Bytes, report.Deleted, report.DryRun)
+		}
+	}()
+}
+
+// handlerGC runs a garbage collection pass right away and returns its report.
+func (cfg *apiConfig) handlerGC(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("GC can only be triggered in dev environment."))
+		return
+	}
+
+	report, err := cfg.collectGarbage(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't collect garbage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
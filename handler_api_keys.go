@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAPIKeyTTL    = 90 * 24 * time.Hour
+	maxAPIKeyNameLength = 100
+)
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string                 `json:"name"`
+		Scopes []database.APIKeyScope `json:"scopes"`
+		// ExpiresAt defaults to defaultAPIKeyTTL from now.
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever shown here.
+		Key string `json:"key"`
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	var params parameters
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || utf8.RuneCountInString(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyNameLength), nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "scopes must name at least one of read, upload and delete", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !scope.Valid() {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope), nil)
+			return
+		}
+	}
+	expiresAt := time.Now().Add(defaultAPIKeyTTL)
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+			return
+		}
+		expiresAt = *params.ExpiresAt
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		KeyHash:   auth.HashAPIKey(key),
+		Prefix:    auth.APIKeyHint(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	keys, err := cfg.db.ListAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyDelete(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	if err := cfg.db.RevokeAPIKey(userID, keyID); err != nil {
+		respondWithDBError(w, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
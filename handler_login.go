@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -29,10 +30,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := cfg.db.GetUserByEmail(params.Email)
-	if err != nil {
+	if errors.Is(err, database.ErrNotFound) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
 
 	match, err := auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
@@ -44,29 +49,33 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
+	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	userAgent, ip := sessionClient(r)
+	session, err := cfg.db.CreateSession(database.CreateSessionParams{
+		UserID:                user.ID,
+		UserAgent:             userAgent,
+		IP:                    ip,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
+	accessToken, err := auth.MakeJWT(
+		user.ID,
+		session.ID,
+		cfg.jwtKeys.keySet(),
+		loginAccessTokenTTL,
+	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
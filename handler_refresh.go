@@ -1,15 +1,24 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+// refreshTokenTTL is how long a refresh token can be used. Every use
+// replaces it with a new one, so a session lasts as long as it is used at
+// least this often.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,16 +27,58 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.RevokedAt != nil {
+		cfg.revokeReusedRefreshToken(w, stored)
+		return
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token expired", nil)
+		return
+	}
+
+	// Touch the session before rotating. Failing after the rotation would
+	// leave the client without the new token, and presenting the old one
+	// again counts as reuse.
+	userAgent, ip := sessionClient(r)
+	if err := cfg.db.TouchSession(stored.FamilyID, userAgent, ip); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update session", err)
+		return
+	}
+
+	nextToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	_, err = cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		Token:     nextToken,
+		UserID:    stored.UserID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+	})
+	if errors.Is(err, database.ErrConflict) {
+		// used twice at the same time, which is as suspicious as reuse
+		cfg.revokeReusedRefreshToken(w, stored)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		stored.UserID,
+		stored.FamilyID,
+		cfg.jwtKeys.keySet(),
+		accessTokenTTL,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
@@ -35,10 +86,24 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: nextToken,
 	})
 }
 
+// revokeReusedRefreshToken responds to a refresh token that was already
+// replaced or revoked being used. Either the client or an attacker holds a
+// stolen copy, and there is no telling which, so every token of the family
+// is revoked and its owner has to log in again.
+func (cfg *apiConfig) revokeReusedRefreshToken(w http.ResponseWriter, token database.RefreshToken) {
+	log.Printf("Refresh token of user %s was reused, revoking its family %s", token.UserID, token.FamilyID)
+	if err := cfg.db.RevokeRefreshTokenFamily(token.FamilyID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh tokens", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", nil)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
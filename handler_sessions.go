@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// maxUserAgentLength caps the user agents stored with sessions; clients
+// choose them freely.
+const maxUserAgentLength = 512
+
+// sessionClient returns the user agent and IP address a session is used
+// from.
+func sessionClient(r *http.Request) (userAgent, ip string) {
+	userAgent = r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return userAgent, ip
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	sessions, err := cfg.db.ListSessions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list sessions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionDelete(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	if err := cfg.db.RevokeSession(userID, r.PathValue("sessionID")); err != nil {
+		respondWithDBError(w, "Couldn't revoke session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsDeleteAll logs the user out everywhere.
+func (cfg *apiConfig) handlerSessionsDeleteAll(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	if err := cfg.db.RevokeAllSessions(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus protocol loosely: a client creates a
+// session with the total size, PATCHes chunks at the offset the server
+// reports, asks for the offset with HEAD after a failure, and finalizes once
+// all bytes are in.
+
+const (
+	maxResumableUploadSize = 10 << 30 // 10 GB
+	uploadSessionTTL       = 24 * time.Hour
+	uploadSweepInterval    = time.Hour
+	uploadChunkMediaType   = "application/offset+octet-stream"
+)
+
+// uploadLock serializes the requests to one upload session. refs counts the
+// requests holding or waiting for it, so it is dropped only once nobody
+// needs it and a later request can't end up with a different mutex.
+type uploadLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	uploadLocksMu sync.Mutex
+	uploadLocks   = map[uuid.UUID]*uploadLock{}
+)
+
+func lockUpload(id uuid.UUID) func() {
+	uploadLocksMu.Lock()
+	l, ok := uploadLocks[id]
+	if !ok {
+		l = &uploadLock{}
+		uploadLocks[id] = l
+	}
+	l.refs++
+	uploadLocksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		uploadLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(uploadLocks, id)
+		}
+		uploadLocksMu.Unlock()
+	}
+}
+
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Length header must be a positive integer", err)
+		return
+	}
+	if length > maxResumableUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusUploading, "")
+	if errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Video is still being processed", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update the video status", err)
+		return
+	}
+
+	partFile, err := os.CreateTemp(cfg.uploadsRoot, "upload-*.part")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	partFile.Close()
+
+	session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+		VideoID:   videoID,
+		UserID:    userID,
+		Length:    length,
+		FilePath:  partFile.Name(),
+		ExpiresAt: time.Now().UTC().Add(uploadSessionTTL),
+	})
+	if err != nil {
+		os.Remove(partFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/uploads/%s", session.ID))
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	respondWithJSON(w, http.StatusCreated, session)
+}
+
+func (cfg *apiConfig) handlerUploadSessionHead(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerUploadSessionPatch(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != uploadChunkMediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+uploadChunkMediaType, nil)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Offset header must be a non-negative integer", err)
+		return
+	}
+
+	unlock := lockUpload(session.ID)
+	defer unlock()
+
+	// re-read under the lock, another chunk may have landed or the upload
+	// been finalized meanwhile
+	session, ok = cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if offset != session.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	partFile, err := os.OpenFile(session.FilePath, os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer partFile.Close()
+
+	// drop bytes written after the last recorded offset, e.g. by a crash
+	if err := partFile.Truncate(session.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+	if _, err := partFile.Seek(session.Offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+
+	remaining := session.Length - session.Offset
+	if r.ContentLength > remaining {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds Upload-Length", nil)
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, remaining)
+	written, copyErr := io.Copy(partFile, body)
+
+	// keep whatever arrived so the client can resume from there
+	if written > 0 {
+		if err := partFile.Sync(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save chunk", err)
+			return
+		}
+		advanced, err := cfg.db.AdvanceUploadSession(session.ID, session.Offset, session.Offset+written)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+			return
+		}
+		if !advanced {
+			respondWithError(w, http.StatusConflict, "Upload session changed while the chunk was written, check Upload-Offset with HEAD", nil)
+			return
+		}
+	}
+	newOffset := session.Offset + written
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+
+	if copyErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(copyErr, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds Upload-Length", copyErr)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't read chunk", copyErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUploadSessionFinalize(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := lockUpload(session.ID)
+	defer unlock()
+
+	// re-read under the lock, a chunk or another finalize may have finished
+	// meanwhile
+	session, ok = cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Offset != session.Length {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		respondWithError(w, http.StatusConflict, "Upload is incomplete", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+
+	var rejection *mediaRejection
+	err = checkMP4File(session.FilePath)
+	if errors.As(err, &rejection) {
+		// the bytes will never pass, so don't let the client resume them
+		if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+			log.Printf("Couldn't delete upload session %s: %v", session.ID, err)
+		}
+		os.Remove(session.FilePath)
+		cfg.failVideo(video.ID, rejection.Error())
+		respondWithMediaRejection(w, rejection)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate the video file", err)
+		return
+	}
+
+	// set before queueing so a fast worker can't finish first
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusProcessing, "")
+	if errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Video is not waiting for an upload", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update the video status", err)
+		return
+	}
+
+	err = cfg.db.CompleteUploadSession(session.ID)
+	if err != nil {
+		cfg.failVideo(video.ID, "could not complete upload session")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete upload session", err)
+		return
+	}
+
+	job, err := cfg.enqueueProcessVideo(video.ID, session.FilePath)
+	if err != nil {
+		cfg.failVideo(video.ID, "could not queue processing")
+		respondWithError(w, http.StatusInternalServerError, "Could not queue the video for processing", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%s", job.ID))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// authorizedUploadSession loads the session named in the path and checks that
+// it belongs to the caller and is still open. It writes the error response
+// itself and reports false when the request must stop.
+func (cfg *apiConfig) authorizedUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	uploadIDString := r.PathValue("uploadID")
+	uploadID, err := uuid.Parse(uploadIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.UploadSession{}, false
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	session, err := cfg.db.GetUploadSession(uploadID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get upload session", err)
+		return database.UploadSession{}, false
+	}
+	if session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload session not found", nil)
+		return database.UploadSession{}, false
+	}
+	if session.CompletedAt != nil || time.Now().UTC().After(session.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload session is closed", nil)
+		return database.UploadSession{}, false
+	}
+
+	return session, true
+}
+
+// sweepUploadSessions deletes expired upload sessions along with the partial
+// files of those that were never finalized. Finalized files belong to their
+// processing jobs.
+func (cfg *apiConfig) sweepUploadSessions() {
+	sessions, err := cfg.db.ListExpiredUploadSessions(time.Now())
+	if err != nil {
+		log.Printf("Couldn't list expired upload sessions: %v", err)
+		return
+	}
+	for _, session := range sessions {
+		unlock := lockUpload(session.ID)
+		if session.CompletedAt == nil {
+			if err := os.Remove(session.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Couldn't remove file of upload session %s: %v", session.ID, err)
+				unlock()
+				continue
+			}
+		}
+		if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+			log.Printf("Couldn't delete upload session %s: %v", session.ID, err)
+		}
+		unlock()
+	}
+}
+
+func (cfg *apiConfig) startUploadSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(uploadSweepInterval)
+		defer ticker.Stop()
+		for {
+			cfg.sweepUploadSessions()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
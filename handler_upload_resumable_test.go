@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func newUploadTestConfig(t *testing.T) (*apiConfig, database.Video) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient("sqlite://" + filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	user, err := db.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := db.CreateVideo(database.CreateVideoParams{Title: "Boots", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{db: db, uploadsRoot: dir, jobWake: make(chan struct{}, 1)}, video
+}
+
+// serveUpload calls handler as the owner of video would through withAuth.
+func serveUpload(handler http.HandlerFunc, video database.Video, method, uploadID string, header http.Header, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.SetPathValue("videoID", video.ID.String())
+	r.SetPathValue("uploadID", uploadID)
+	r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal{Kind: principalLogin, UserID: video.UserID}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func chunkHeader(offset int) http.Header {
+	return http.Header{
+		"Content-Type":  {uploadChunkMediaType},
+		"Upload-Offset": {strconv.Itoa(offset)},
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	cfg, video := newUploadTestConfig(t)
+
+	w := serveUpload(cfg.handlerUploadSessionCreate, video, "POST", "", http.Header{"Upload-Length": {"10"}}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	uploadID := strings.TrimPrefix(w.Header().Get("Location"), "/api/uploads/")
+
+	w = serveUpload(cfg.handlerUploadSessionPatch, video, "PATCH", uploadID, chunkHeader(0), "abcd")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("first chunk = %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// a retried chunk that already landed doesn't match the offset anymore
+	w = serveUpload(cfg.handlerUploadSessionPatch, video, "PATCH", uploadID, chunkHeader(0), "abcd")
+	if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "4" {
+		t.Errorf("chunk at a stale offset = %d, offset %s; want 409 at 4", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	w = serveUpload(cfg.handlerUploadSessionFinalize, video, "POST", uploadID, nil, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("finalizing an incomplete upload = %d, want 409", w.Code)
+	}
+
+	// resume where HEAD says the upload stopped
+	w = serveUpload(cfg.handlerUploadSessionHead, video, "HEAD", uploadID, nil, "")
+	offset, _ := strconv.Atoi(w.Header().Get("Upload-Offset"))
+	if w.Code != http.StatusOK || offset != 4 {
+		t.Fatalf("HEAD = %d, offset %d; want 200 at 4", w.Code, offset)
+	}
+	w = serveUpload(cfg.handlerUploadSessionPatch, video, "PATCH", uploadID, chunkHeader(offset), "efghij")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("resumed chunk = %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	id, _ := uuid.Parse(uploadID)
+	session, err := cfg.db.GetUploadSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(session.FilePath); string(b) != "abcdefghij" {
+		t.Errorf("upload file = %q, want the chunks in order", b)
+	}
+
+	// the bytes aren't an MP4, so finalizing rejects them for good
+	w = serveUpload(cfg.handlerUploadSessionFinalize, video, "POST", uploadID, nil, "")
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("finalizing a non-MP4 upload = %d, want 415", w.Code)
+	}
+	if _, err := cfg.db.GetUploadSession(id); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("session after a rejected finalize = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(session.FilePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("upload file after a rejected finalize = %v, want it removed", err)
+	}
+}
+
+func TestSweepUploadSessions(t *testing.T) {
+	cfg, video := newUploadTestConfig(t)
+
+	create := func(expiresAt time.Time) database.UploadSession {
+		t.Helper()
+		path := filepath.Join(cfg.uploadsRoot, uuid.NewString()+".part")
+		if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+			VideoID:   video.ID,
+			UserID:    video.UserID,
+			Length:    10,
+			FilePath:  path,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+	expired := create(time.Now().Add(-time.Minute))
+	open := create(time.Now().Add(time.Hour))
+
+	cfg.sweepUploadSessions()
+
+	if _, err := cfg.db.GetUploadSession(expired.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expired session after sweeping = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(expired.FilePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file of the expired session = %v, want it removed", err)
+	}
+	if _, err := cfg.db.GetUploadSession(open.ID); err != nil {
+		t.Errorf("open session after sweeping = %v, want it kept", err)
+	}
+	if _, err := os.Stat(open.FilePath); err != nil {
+		t.Errorf("file of the open session = %v, want it kept", err)
+	}
+}
+
+func TestLockUpload(t *testing.T) {
+	id := uuid.New()
+	unlock := lockUpload(id)
+
+	acquired := make(chan func())
+	go func() { acquired <- lockUpload(id) }()
+	select {
+	case <-acquired:
+		t.Fatal("second lockUpload didn't wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// releasing the first lock must hand the same mutex to the waiter
+	// rather than dropping it
+	unlock()
+	unlockSecond := <-acquired
+	uploadLocksMu.Lock()
+	_, held := uploadLocks[id]
+	uploadLocksMu.Unlock()
+	if !held {
+		t.Error("lock was dropped while still held")
+	}
+
+	unlockSecond()
+	uploadLocksMu.Lock()
+	_, held = uploadLocks[id]
+	uploadLocksMu.Unlock()
+	if held {
+		t.Error("lock was kept after its last holder released it")
+	}
+}
@@ -1,16 +1,11 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -23,40 +18,46 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 	
+	const maxSize = 20 << 20 // 20 MB
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
 	const maxMemory = 10 << 20
 
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is larger than 20 MB", err)
+			return
+		}
 		http.Error(w, "could not parse multipart form", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("thumbnail"); 
+	file, _, err := r.FormFile("thumbnail"); 
 	if err != nil {
     http.Error(w, "could not read file from form", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	mediaType := header.Header.Get("Content-Type")	
-
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusUnauthorized, "Unsupported media type. Only JPEG and PNG are allowed.", err)
-		return 
+	// the declared Content-Type is ignored, the bytes have to decode
+	img, err := decodeThumbnail(file)
+	if errors.Is(err, errNotAnImage) {
+		respondWithMediaRejection(w, &mediaRejection{
+			Reason:  rejectNotAnImage,
+			Detail:  err.Error(),
+			Allowed: []string{"image/jpeg", "image/png", "image/webp"},
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read the image", err)
+		return
 	}
 
 	//fmt.Println("media type:", mediaType)
@@ -71,56 +72,34 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	//thumbnailURL := fmt.Sprintf("http://localhost:%v/assets/%v", cfg.port, thumbFile)
 
 	
-	// 3 - Saving file to random characters to avoid caching issues
-	
-	// generate random 32 bytes file path
-	key := make([]byte, 32)
-	rand.Read(key)
-
-	// encode to base64
-	filePath := base64.RawURLEncoding.EncodeToString(key)	
-	thumbFile := fmt.Sprintf("%v.%s", filePath, mediaType[len("image/"):])
-	fileURL := filepath.Join(cfg.assetsRoot, thumbFile)	
-	thumbnailURL := fmt.Sprintf("http://localhost:%v/assets/%v", cfg.port, thumbFile)
-	
-	createFile, err := os.Create(fileURL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	
-	defer createFile.Close()	
-
-	if _, err := io.Copy(createFile, file); err != nil {
-		log.Fatal(err)
-	}
-	
-
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-    http.Error(w, "could not get the video", http.StatusBadRequest)
+		respondWithDBError(w, "Couldn't get video", err)
 		return
 	}
 
 	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", err)
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
 		return
 	}
-	
-	
-	video.ThumbnailURL = &thumbnailURL
-	fmt.Println("thumb URL:", thumbnailURL)
-	
-	err = cfg.db.UpdateVideo(video)
+
+	// 3 - Saving file to random characters to avoid caching issues
+	thumbnails, err := cfg.publishThumbnail(r.Context(), img)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not update the video", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not save the thumbnail", err)
 		return
 	}
 
-	video, err = cfg.db.GetVideo(videoID)
+	video, err = cfg.updateVideo(videoID, func(video *database.Video) error {
+		setThumbnails(video, thumbnails)
+		return nil
+	})
 	if err != nil {
-    http.Error(w, "could not get the video", http.StatusBadRequest)
+		respondWithDBError(w, "Could not update the video", err)
 		return
 	}
+	fmt.Println("thumb key:", video.ThumbnailObject.Key)
 
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
 }
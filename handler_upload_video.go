@@ -1,23 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
-	"mime"
 	"net/http"
 	"os"
 	"os/exec"
-	"strings"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -30,17 +24,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
 	fmt.Println("[!] uploading video", videoID, "by user", userID)	
 	
@@ -49,202 +33,261 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-    http.Error(w, "could not get the video", http.StatusBadRequest)
+		respondWithDBError(w, "Couldn't get video", err)
 		return
 	}
 
 	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", err)
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
 		return
 	}
 
 	// -----------------------------------	
 
-	file, header, err := r.FormFile("video"); 
+	file, _, err := r.FormFile("video"); 
 	if err != nil {
     http.Error(w, "could not read file from form", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	mediaType := header.Header.Get("Content-Type")
+	// the declared Content-Type is ignored, the bytes have to look like MP4
+	var rejection *mediaRejection
+	err = sniffMP4(file)
+	if errors.As(err, &rejection) {
+		respondWithMediaRejection(w, rejection)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read the video file", err)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read the video file", err)
+		return
+	}
 
-	mediaCheck, _, err := mime.ParseMediaType(mediaType)
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusUploading, "")
+	if errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Video is still being processed", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unsupported media type. Only MP4 is allowed.", err)
-		return 
-	}	
-	if mediaCheck != "video/mp4" {
-		respondWithError(w, http.StatusUnauthorized, "file is not an MP4", nil)
-		return 
-	}		
-	
-	createFile, err := os.CreateTemp("", "tubely-upload.mp4")
+		respondWithError(w, http.StatusInternalServerError, "Could not update the video status", err)
+		return
+	}
+
+	// kept in uploadsRoot until the processing job is done with it
+	createFile, err := os.CreateTemp(cfg.uploadsRoot, "upload-*.mp4")
 	if err != nil {
+		cfg.failVideo(video.ID, "could not store upload")
 		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
 		return
 	}
-	
-	defer os.Remove(createFile.Name())	
-	defer createFile.Close()	
+	defer createFile.Close()
 
 	if _, err := io.Copy(createFile, file); err != nil {
+		os.Remove(createFile.Name())
+		cfg.failVideo(video.ID, "could not store upload")
 		respondWithError(w, http.StatusInternalServerError, "Could not save the video file", err)
 		return
-	}	
+	}
 
-	aspectRatio, err := getVideoAspectRatio(createFile.Name())
+	err = validateMP4(createFile.Name())
+	if errors.As(err, &rejection) {
+		os.Remove(createFile.Name())
+		cfg.failVideo(video.ID, rejection.Error())
+		respondWithMediaRejection(w, rejection)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not get video aspect ratio", err)
+		os.Remove(createFile.Name())
+		cfg.failVideo(video.ID, "could not validate upload")
+		respondWithError(w, http.StatusInternalServerError, "Could not validate the video file", err)
 		return
 	}
 
-	processedVideoPath, err := processVideoForFastStart(createFile.Name())
+	// set before queueing so a fast worker can't finish first
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusProcessing, "")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not process video for fast start", err)
+		os.Remove(createFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Could not update the video status", err)
 		return
 	}
 
-	// open the processed file
-	processedFile, err := os.Open(processedVideoPath)
+	job, err := cfg.enqueueProcessVideo(video.ID, createFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed video", err)
+		os.Remove(createFile.Name())
+		cfg.failVideo(video.ID, "could not queue processing")
+		respondWithError(w, http.StatusInternalServerError, "Could not queue the video for processing", err)
 		return
 	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%s", job.ID))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// publishVideo runs the processing pipeline on the uploaded MP4 at srcPath,
+// stores the result and points video at it.
+func (cfg *apiConfig) publishVideo(ctx context.Context, video database.Video, srcPath string) (database.Video, error) {
+	info, err := probeMediaInfo(srcPath)
+	if err != nil {
+		return video, fmt.Errorf("could not probe video: %w", err)
+	}
+	info.VideoID = video.ID
+
+	width, height, err := getVideoDimensions(srcPath)
+	if err != nil {
+		return video, fmt.Errorf("could not get video dimensions: %w", err)
+	}
+	aspectRatio := aspectRatioCategory(width, height)
+
+	processedVideoPath, err := processVideoForFastStart(srcPath)
+	if err != nil {
+		return video, fmt.Errorf("could not process video for fast start: %w", err)
+	}
+	defer os.Remove(processedVideoPath)
+
+	processedFile, err := os.Open(processedVideoPath)
+	if err != nil {
+		return video, fmt.Errorf("could not open processed video: %w", err)
+	}
 	defer processedFile.Close()
-	
+
 	// generate random 32 bytes file path
 	key := make([]byte, 32)
 	rand.Read(key)
 
 	// encode to base64
-	filePath := base64.RawURLEncoding.EncodeToString(key)	
+	filePath := base64.RawURLEncoding.EncodeToString(key)
 
 	videoFile := fmt.Sprintf("%v/%v.%s", aspectRatio, filePath, "mp4")
-	//videoURL := fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", cfg.s3Bucket, cfg.s3Region, videoFile) // direct URL version
-	
-	//videoURL := fmt.Sprintf("%v,%v", cfg.s3Bucket, videoFile) // presigned URL version
-		
-	videoURL := fmt.Sprintf("https://%v/%v", cfg.s3CfDistribution, videoFile) // CDN version
-	
-	
 
-	// reset file pointer
-	
-	createFile.Seek(0, io.SeekStart)		
-
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &videoFile,
-		Body:        processedFile,
-		ContentType: &mediaCheck,
-	})
+	err = cfg.videoStore.Put(ctx, videoFile, processedFile, "video/mp4")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not upload the video to S3", err)
-		return
+		return video, fmt.Errorf("could not upload the video: %w", err)
 	}
 
-	video.VideoURL = &videoURL
-	fmt.Println("video URL:", videoURL)
+	// HLS renditions live next to the MP4: <aspect>/<key>/master.m3u8
+	hlsPrefix := fmt.Sprintf("%v/%v", aspectRatio, filePath)
+	manifestKey, err := cfg.publishHLS(ctx, processedVideoPath, width, height, hlsPrefix)
+	if err != nil {
+		return video, fmt.Errorf("could not package HLS renditions: %w", err)
+	}
 
-	
-	err = cfg.db.UpdateVideo(video)
+	generated := video
+	cfg.generateThumbnails(ctx, &generated, processedVideoPath)
+
+	fmt.Println("video key:", videoFile)
+	// processing takes a while; keep whatever the owner changed meanwhile
+	video, err = cfg.updateVideo(video.ID, func(video *database.Video) error {
+		video.VideoObject = cfg.videoRef(videoFile)
+		video.ManifestObject = cfg.videoRef(manifestKey)
+		if video.ThumbnailObject == nil {
+			video.ThumbnailObject = generated.ThumbnailObject
+			video.ThumbnailObjects = generated.ThumbnailObjects
+		}
+		if generated.ThumbnailSpriteObject != nil {
+			video.ThumbnailSpriteObject = generated.ThumbnailSpriteObject
+		}
+		return nil
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not update the video", err)
-		return
+		return video, fmt.Errorf("could not update the video: %w", err)
 	}
 
-	video, err = cfg.db.GetVideo(videoID)
+	err = cfg.db.UpsertMediaInfo(info)
 	if err != nil {
-    http.Error(w, "could not get the video", http.StatusBadRequest)
-		return
+		return video, fmt.Errorf("could not store media info: %w", err)
 	}
 
-	/*
-	signedVideo, err := cfg.dbVideoToSignedVideo(video)
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusReady, "")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-		return
+		return video, fmt.Errorf("could not mark the video ready: %w", err)
 	}
-	*/
-	respondWithJSON(w, http.StatusOK, video)
+
+	return cfg.db.GetVideoIncludingTrashed(video.ID)
 }
 
 func getVideoAspectRatio(filePath string) (string, error) {
-	type FFProbeOutput struct {
-			Streams []struct {
-					Width  int `json:"width"`
-					Height int `json:"height"`
-			} `json:"streams"`
-	}
-
-	cmd := exec.Command(
-			"ffprobe",
-			"-v", "error",
-			"-print_format", "json",
-			"-show_streams",
-			filePath,
-	)
-	
-	var out bytes.Buffer
-	cmd.Stdout = &out	
-	if err := cmd.Run(); err != nil {
-		return "", err
-	}
-	
-	var probe FFProbeOutput
-	if err := json.Unmarshal(out.Bytes(), &probe); err != nil  {
+	width, height, err := getVideoDimensions(filePath)
+	if err != nil {
 		return "", err
 	}
 
-	if len(probe.Streams) == 0 {
-		return "", fmt.Errorf("no streams found in video")
-	}
+  return aspectRatioCategory(width, height), nil
+}
 
-	width := probe.Streams[0].Width
-	height := probe.Streams[0].Height
+// aspectRatioCategory returns the storage prefix for a video of the given
+// display size: "landscape", "portrait", "square", "standard" (4:3),
+// "ultrawide" (21:9) or "other".
+func aspectRatioCategory(width, height int) string {
+	switch detectAspectRatio(width, height) {
+	case "16:9":
+		return "landscape"
+	case "9:16":
+		return "portrait"
+	case "1:1":
+		return "square"
+	case "4:3":
+		return "standard"
+	case "21:9":
+		return "ultrawide"
+	}
+	return "other"
+}
 
-	if width == 0 || height == 0 {
-		return "", fmt.Errorf("invalid video dimensions")
+// getVideoDimensions returns the display size of the first video stream,
+// i.e. after applying its rotation and sample aspect ratio.
+func getVideoDimensions(filePath string) (int, int, error) {
+	probe, err := runFFprobe(filePath)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	 // "portrait" / "landscape" / "other"
-	aspectRatio := detectAspectRatio(width, height)	
+	stream := probe.firstStream("video")
+	if stream == nil {
+		return 0, 0, fmt.Errorf("no video stream found")
+	}
 
-	switch aspectRatio {
-	case "16:9":
-		aspectRatio = "landscape"
-	case "9:16":
-		aspectRatio = "portrait"
-	default:
-		aspectRatio = "other"
+	width, height := stream.displaySize()
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("invalid video dimensions")
 	}
 
-  return aspectRatio, nil  
+	return width, height, nil
+}
 
+// aspectRatios are the ratios detectAspectRatio recognizes.
+var aspectRatios = []struct {
+	name  string
+	ratio float64
+}{
+	{"16:9", 16.0 / 9.0},
+	{"9:16", 9.0 / 16.0},
+	{"1:1", 1},
+	{"4:3", 4.0 / 3.0},
+	{"21:9", 21.0 / 9.0},
 }
 
+// detectAspectRatio returns the name of the ratio in aspectRatios that
+// width x height is within 3% of, or "other".
 func detectAspectRatio(width, height int) string {
-			const tolerance = 0.03 // 3%
-
-			w := float64(width)
-			h := float64(height)
+	const tolerance = 0.03 // 3%
 
-			// Compare ratios
-			ratio := w / h
-
-			ratio16x9 := 16.0 / 9.0
-			ratio9x16 := 9.0 / 16.0
-
-			if isApprox(ratio, ratio16x9, tolerance) {
-					return "16:9"
-			}
+	if width <= 0 || height <= 0 {
+		return "other"
+	}
+	ratio := float64(width) / float64(height)
 
-			if isApprox(ratio, ratio9x16, tolerance) {
-					return "9:16"
-			}
+	for _, r := range aspectRatios {
+		if isApprox(ratio, r.ratio, tolerance) {
+			return r.name
+		}
+	}
 
-			return "other"
+	return "other"
 }
 
 func isApprox(a, b, tolerance float64) bool {
@@ -256,6 +299,8 @@ func processVideoForFastStart(filePath string) (string, error) {
 	outputPath := filePath + ".processing"
 	cmd := exec.Command(
 			"ffmpeg",
+			// overwrite what a failed earlier attempt left behind
+			"-y",
 			"-i", filePath,
 			"-c", "copy",
 			"-movflags", "faststart",
@@ -263,56 +308,8 @@ func processVideoForFastStart(filePath string) (string, error) {
 			outputPath,
 	)
 	if err := cmd.Run(); err != nil {
+			os.Remove(outputPath)
 			return "", err
 	}
 	return outputPath, nil
 }
-
-// s3 presigned URL generation - removed after cdn integration
-func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-	// Create a presign client
-	presignClient := s3.NewPresignClient(s3Client)
-
-	// Create the presigned URL
-	presignedReq, err := presignClient.PresignGetObject(
-			context.TODO(),
-			&s3.GetObjectInput{
-					Bucket: &bucket,
-					Key:    &key,
-			},
-			s3.WithPresignExpires(expireTime),
-	)
-	if err != nil {
-			return "", err
-	}
-
-	return presignedReq.URL, nil
-
-}
-
-func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-	// 1. Split "bucket,key"
-	if video.VideoURL == nil {
-    return video, nil
-  }
-
-	parts := strings.Split(*video.VideoURL, ",")
-	if len(parts) < 2 {
-			return video, nil
-	}
-
-	bucket := parts[0]
-	key := parts[1]
-
-	// 2. Generate presigned URL
-	signedURL, err := generatePresignedURL(cfg.s3Client, bucket, key, 5*time.Minute)
-	if err != nil {
-			return video, err
-	}
-
-	// 3. Replace VideoURL with presigned URL
-	video.VideoURL = &signedURL
-
-	// 4. Return updated video
-	return video, nil
-}
\ No newline at end of file
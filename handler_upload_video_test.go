@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestDetectAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          string
+	}{
+		{"1080p landscape", 1920, 1080, "16:9"},
+		{"720p landscape", 1280, 720, "16:9"},
+		{"1080p portrait", 1080, 1920, "9:16"},
+		{"square", 1080, 1080, "1:1"},
+		{"nearly square", 1080, 1060, "1:1"},
+		{"4:3", 640, 480, "4:3"},
+		{"21:9", 2560, 1080, "21:9"},
+		{"cinemascope", 2390, 1000, "21:9"},
+		{"3:2", 1500, 1000, "other"},
+		{"3:4 portrait", 480, 640, "other"},
+		{"zero height", 1920, 0, "other"},
+		{"zero width", 0, 1080, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectAspectRatio(tt.width, tt.height); got != tt.want {
+				t.Errorf("detectAspectRatio(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAspectRatioCategory(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, "landscape"},
+		{1080, 1920, "portrait"},
+		{720, 720, "square"},
+		{1024, 768, "standard"},
+		{2560, 1080, "ultrawide"},
+		{1000, 300, "other"},
+	}
+	for _, tt := range tests {
+		if got := aspectRatioCategory(tt.width, tt.height); got != tt.want {
+			t.Errorf("aspectRatioCategory(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,8 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -15,20 +13,11 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
@@ -41,7 +30,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	cfg.respondWithVideo(w, r, http.StatusCreated, video)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -52,33 +41,33 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r.Context()).UserID
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	_, err = cfg.db.TrashVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
 
+	if cfg.trashPeriod == 0 {
+		err = cfg.purgeVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -92,49 +81,74 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != principalFrom(r.Context()).UserID {
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
 		return
 	}
 
-	/*
-	signedVideo, err := cfg.dbVideoToSignedVideo(video)
+	mediaInfo, err := cfg.db.GetMediaInfo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media info", err)
 		return
-	}*/
-
-	respondWithJSON(w, http.StatusOK, video)
-}
+	}
 
-func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	video, err = cfg.resolveVideoURLs(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	type response struct {
+		database.Video
+		MediaInfo *database.MediaInfo `json:"media_info"`
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, response{
+		Video:     video,
+		MediaInfo: mediaInfo,
+	})
+}
+
+func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	params, err := parseListVideosQuery(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	params.UserID = userID
 
-	videos, err := cfg.db.GetVideos(userID)
+	videos, next, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	for x, video := range videos {
-
-		signedVideo, err := cfg.dbVideoToSignedVideo(video)
+	for i, video := range videos {
+		videos[i], err = cfg.resolveVideoURLs(r.Context(), video)
 		if err != nil {
-			fmt.Println("presign error:", err)
-			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
 			return
 		}
-		videos[x] = signedVideo
 	}
-		
 
-	respondWithJSON(w, http.StatusOK, videos)
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+	resp := response{Videos: videos}
+	if next != nil {
+		cursor := encodeVideoCursor(videoCursor{
+			After:      *next,
+			Sort:       params.Sort,
+			Descending: params.Descending,
+		})
+		resp.NextCursor = &cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// thumbnailForm is a multipart body uploading a small PNG as thumbnail.
+func thumbnailForm(t *testing.T) (body *bytes.Buffer, contentType string) {
+	t.Helper()
+	body = &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("thumbnail", "thumbnail.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(part, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+	return body, form.FormDataContentType()
+}
+
+func TestVideoRoutesHideOtherUsersVideos(t *testing.T) {
+	cfg, video := newUploadTestConfig(t)
+	other, err := cfg.db.CreateUser(database.CreateUserParams{Email: "other@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnail, thumbnailType := thumbnailForm(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		header  http.Header
+		body    *bytes.Buffer
+	}{
+		{"get", cfg.handlerVideoGet, "GET", nil, nil},
+		{"update", cfg.handlerVideoUpdate, "PATCH", http.Header{"Content-Type": {"application/json"}}, bytes.NewBufferString(`{"title": "Mine now"}`)},
+		{"delete", cfg.handlerVideoMetaDelete, "DELETE", nil, nil},
+		{"upload video", cfg.handlerUploadVideo, "POST", nil, nil},
+		{"upload thumbnail", cfg.handlerUploadThumbnail, "POST", http.Header{"Content-Type": {thumbnailType}}, thumbnail},
+		{"upload session", cfg.handlerUploadSessionCreate, "POST", http.Header{"Upload-Length": {"10"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+			if body == nil {
+				body = &bytes.Buffer{}
+			}
+			r := httptest.NewRequest(tt.method, "/", body)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			r.SetPathValue("videoID", video.ID.String())
+			r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal{Kind: principalLogin, UserID: other.ID}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s of another user's video = %d %s, want 404", tt.name, w.Code, w.Body)
+			}
+		})
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("video after other users' requests: %v", err)
+	}
+	if got.Title != video.Title || got.Version != video.Version {
+		t.Errorf("video = %q version %d, want it untouched", got.Title, got.Version)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// searchCursor is what next_cursor of a search encodes. Results are ranked,
+// so pages are plain offsets into them.
+type searchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"offset"`
+}
+
+func encodeSearchCursor(cursor searchCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSearchCursor(s string) (searchCursor, error) {
+	var cursor searchCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// highlightHTML escapes text from a search result and turns its highlight
+// markers into <mark> elements.
+func highlightHTML(text string) string {
+	return strings.NewReplacer(
+		database.HighlightStart, "<mark>",
+		database.HighlightEnd, "</mark>",
+	).Replace(html.EscapeString(text))
+}
+
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r.Context()).UserID
+
+	query := r.URL.Query()
+	params := database.SearchVideosParams{
+		UserID: userID,
+		Query:  query.Get("q"),
+		Limit:  defaultVideoPageSize,
+	}
+	if len(database.SearchTerms(params.Query)) == 0 {
+		respondWithError(w, http.StatusBadRequest, "q must contain at least one word", nil)
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		var err error
+		params.Limit, err = strconv.Atoi(v)
+		if err != nil || params.Limit < 1 || params.Limit > maxVideoPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxVideoPageSize), err)
+			return
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeSearchCursor(v)
+		if err != nil || cursor.Offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+		if cursor.Query != params.Query {
+			respondWithError(w, http.StatusBadRequest, "cursor was issued for a different search", nil)
+			return
+		}
+		params.Offset = cursor.Offset
+	}
+
+	results, more, err := cfg.db.SearchVideos(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	type result struct {
+		Video              database.Video `json:"video"`
+		Rank               float64        `json:"rank"`
+		TitleHighlight     string         `json:"title_highlight"`
+		DescriptionSnippet string         `json:"description_snippet"`
+	}
+	type response struct {
+		Results    []result `json:"results"`
+		NextCursor *string  `json:"next_cursor"`
+	}
+	resp := response{Results: make([]result, 0, len(results))}
+	for _, res := range results {
+		video, err := cfg.resolveVideoURLs(r.Context(), res.Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+			return
+		}
+		resp.Results = append(resp.Results, result{
+			Video:              video,
+			Rank:               res.Rank,
+			TitleHighlight:     highlightHTML(res.TitleHighlight),
+			DescriptionSnippet: highlightHTML(res.DescriptionSnippet),
+		})
+	}
+	if more {
+		next := encodeSearchCursor(searchCursor{Query: params.Query, Offset: params.Offset + len(results)})
+		resp.NextCursor = &next
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+// videoETag identifies the version of a video, for If-Match on updates.
+func videoETag(video database.Video) string {
+	return `"` + strconv.Itoa(video.Version) + `"`
+}
+
+// ifMatch reports whether an If-Match header value allows changing a
+// resource with the given ETag. An empty header allows anything.
+func ifMatch(header, etag string) bool {
+	if header == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// videoPatch is the body of PATCH /api/videos/{videoID}. Fields left out
+// are left unchanged.
+type videoPatch struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
+// validate returns an error for the client if a field is invalid.
+func (p videoPatch) validate() error {
+	if p.Title != nil {
+		title := strings.TrimSpace(*p.Title)
+		if title == "" {
+			return fmt.Errorf("title can't be empty")
+		}
+		if utf8.RuneCountInString(title) > maxVideoTitleLength {
+			return fmt.Errorf("title can't be longer than %d characters", maxVideoTitleLength)
+		}
+	}
+	if p.Description != nil && utf8.RuneCountInString(*p.Description) > maxVideoDescriptionLength {
+		return fmt.Errorf("description can't be longer than %d characters", maxVideoDescriptionLength)
+	}
+	return nil
+}
+
+func (p videoPatch) apply(video *database.Video) {
+	if p.Title != nil {
+		video.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.Description != nil {
+		video.Description = *p.Description
+	}
+}
+
+// errPreconditionFailed is returned by updates whose If-Match doesn't match
+// the stored video.
+var errPreconditionFailed = errors.New("video was changed since it was read")
+
+func (cfg *apiConfig) handlerVideoUpdate(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	var patch videoPatch
+	if err := decoder.Decode(&patch); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := patch.validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		// as if it didn't exist, so IDs of other users' videos can't be probed
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	// Without If-Match a patch is simply applied again on top of concurrent
+	// changes; with it, any concurrent change fails the request.
+	precondition := r.Header.Get("If-Match")
+	video, err = cfg.updateVideo(videoID, func(video *database.Video) error {
+		if video.DeletedAt != nil {
+			return fmt.Errorf("video was moved to the trash: %w", database.ErrConflict)
+		}
+		if !ifMatch(precondition, videoETag(*video)) {
+			return errPreconditionFailed
+		}
+		patch.apply(video)
+		return nil
+	})
+	if errors.Is(err, errPreconditionFailed) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was changed since it was read", err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, "Couldn't update video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
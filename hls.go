@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type hlsRendition struct {
+	Name         string
+	ShortSide    int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+// hlsLadder is ordered from highest to lowest quality. ShortSide is the
+// height of landscape video and the width of portrait video.
+var hlsLadder = []hlsRendition{
+	{Name: "1080p", ShortSide: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	{Name: "720p", ShortSide: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "480p", ShortSide: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	{Name: "360p", ShortSide: 360, VideoBitrate: 800, AudioBitrate: 96},
+}
+
+const hlsSegmentSeconds = 6
+
+// hlsRenditionsFor picks the renditions that don't upscale the source. The
+// lowest rendition is always kept so tiny sources still get a stream.
+func hlsRenditionsFor(width, height int) []hlsRendition {
+	shortSide := min(width, height)
+	renditions := []hlsRendition{}
+	for _, r := range hlsLadder {
+		if r.ShortSide <= shortSide {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) == 0 {
+		renditions = append(renditions, hlsLadder[len(hlsLadder)-1])
+	}
+	return renditions
+}
+
+// scaledSize returns the output dimensions of r for a width x height source,
+// rounded to even numbers as libx264 requires.
+func (r hlsRendition) scaledSize(width, height int) (int, int) {
+	even := func(n int) int { return n / 2 * 2 }
+	if width >= height {
+		return even(width * r.ShortSide / height), r.ShortSide
+	}
+	return r.ShortSide, even(height * r.ShortSide / width)
+}
+
+// packageHLS transcodes srcPath into outDir/<rendition>/index.m3u8 plus
+// segments, and writes outDir/master.m3u8 referencing every rendition.
+func packageHLS(srcPath, outDir string, width, height int) error {
+	renditions := hlsRenditionsFor(width, height)
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+
+	for _, r := range renditions {
+		renditionDir := filepath.Join(outDir, r.Name)
+		if err := os.MkdirAll(renditionDir, 0755); err != nil {
+			return err
+		}
+
+		w, h := r.scaledSize(width, height)
+		cmd := exec.Command(
+			"ffmpeg",
+			"-i", srcPath,
+			"-vf", fmt.Sprintf("scale=%d:%d,setsar=1", w, h),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-profile:v", "main",
+			"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+			// keyframes at segment boundaries by time, whatever the frame rate
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds),
+			"-sc_threshold", "0",
+			"-c:a", "aac",
+			"-b:a", fmt.Sprintf("%dk", r.AudioBitrate),
+			"-ac", "2",
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(renditionDir, "segment_%04d.ts"),
+			filepath.Join(renditionDir, "index.m3u8"),
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("ffmpeg %s: %w: %s", r.Name, err, lastLine(out))
+		}
+
+		bandwidth := (r.VideoBitrate + r.AudioBitrate) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"\n", bandwidth, w, h, r.Name)
+		fmt.Fprintf(&master, "%s/index.m3u8\n", r.Name)
+	}
+
+	return os.WriteFile(filepath.Join(outDir, "master.m3u8"), []byte(master.String()), 0644)
+}
+
+// publishHLS packages srcPath and uploads the result under prefix. It returns
+// the key of the master playlist.
+func (cfg *apiConfig) publishHLS(ctx context.Context, srcPath string, width, height int, prefix string) (string, error) {
+	outDir, err := os.MkdirTemp("", "tubely-hls-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := packageHLS(srcPath, outDir, width, height); err != nil {
+		return "", err
+	}
+
+	err = filepath.WalkDir(outDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outDir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cfg.videoStore.Put(ctx, path.Join(prefix, filepath.ToSlash(rel)), f, hlsContentType(p))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(prefix, "master.m3u8"), nil
+}
+
+func hlsContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	}
+	return "application/octet-stream"
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// hlsURLTTL is how long the playlist and segment links of a presigned HLS
+// stream work. Players load a VOD playlist once and then fetch its segments
+// for as long as the video plays, so it is much longer than presignedURLTTL.
+const hlsURLTTL = 6 * time.Hour
+
+// maxPlaylistSize caps the playlists read into memory for rewriting.
+const maxPlaylistSize = 1 << 20
+
+// In presigned mode, a presigned master playlist wouldn't help: players
+// fetch the rendition playlists and segments it lists relative to it, and
+// those aren't signed. Instead manifest_url points at /api/hls/{token}/,
+// which serves the playlists of one package with every segment replaced by
+// a presigned URL. Rendition playlists stay relative, so they are fetched
+// through the same token.
+
+// hlsManifestURL returns the URL of the master playlist ref through
+// /api/hls.
+func (cfg *apiConfig) hlsManifestURL(ref database.ObjectRef) (string, error) {
+	token, err := auth.MakeHLSToken(path.Dir(ref.Key), cfg.jwtKeys.keySet(), hlsURLTTL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/api/hls/%s/%s", cfg.port, token, path.Base(ref.Key)), nil
+}
+
+// handlerHLSPlaylist serves a playlist of the package an HLS token grants
+// access to, with its segments presigned. The token is the credential, so
+// the route isn't behind withAuth.
+func (cfg *apiConfig) handlerHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	dir, err := cfg.jwtKeys.validateHLS(r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid HLS token", err)
+		return
+	}
+	name := r.PathValue("path")
+	key := path.Join(dir, name)
+	if path.Ext(name) != ".m3u8" || !strings.HasPrefix(key, dir+"/") {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	body, _, err := cfg.videoStore.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	defer body.Close()
+	playlist, err := io.ReadAll(io.LimitReader(body, maxPlaylistSize))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read playlist", err)
+		return
+	}
+
+	playlist, err = cfg.presignPlaylist(r.Context(), path.Dir(key), playlist)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign playlist", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(playlist)
+}
+
+// presignPlaylist replaces the relative segment URIs of a playlist in
+// directory dir with presigned URLs. URIs of other playlists are left
+// relative.
+func (cfg *apiConfig) presignPlaylist(ctx context.Context, dir string, playlist []byte) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || path.Ext(line) == ".m3u8" || strings.Contains(line, "://") {
+			out.WriteString(line + "\n")
+			continue
+		}
+		signed, err := cfg.videoStore.PresignGet(ctx, path.Join(dir, line), hlsURLTTL)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(signed + "\n")
+	}
+	return out.Bytes(), scanner.Err()
+}
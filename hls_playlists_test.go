@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestPresignedHLS(t *testing.T) {
+	cfg, video := newUploadTestConfig(t)
+	store := storage.NewMemoryStore("https://tubely.s3.amazonaws.com")
+	cfg.videoStore = store
+	cfg.storageBackend = storage.BackendS3
+	cfg.s3Bucket = "tubely"
+	cfg.urlMode = urlModePresigned
+	cfg.port = "8091"
+	cfg.jwtKeys = &jwtKeys{algorithm: auth.AlgorithmHS256, secret: "secret"}
+	if err := cfg.jwtKeys.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := map[string]string{
+		"hls/v1/master.m3u8":            "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n360p/index.m3u8\n",
+		"hls/v1/360p/index.m3u8":        "#EXTM3U\n#EXTINF:6.0,\nsegment_0000.ts\n#EXTINF:4.0,\nsegment_0001.ts\n#EXT-X-ENDLIST\n",
+		"hls/v1/360p/segment_0000.ts":   "ts",
+		"hls/v1/360p/segment_0001.ts":   "ts",
+		"hls/other/master.m3u8":         "#EXTM3U\n",
+		"hls/v1/360p/segment_0002.json": "{}",
+	}
+	for key, body := range objects {
+		if err := store.Put(context.Background(), key, strings.NewReader(body), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	video.ManifestObject = cfg.videoRef("hls/v1/master.m3u8")
+
+	video, err := cfg.resolveVideoURLs(context.Background(), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ManifestURL == nil || !strings.HasPrefix(*video.ManifestURL, "http://localhost:8091/api/hls/") {
+		t.Fatalf("manifest_url = %v, want a /api/hls URL", video.ManifestURL)
+	}
+	base := strings.TrimSuffix(strings.TrimPrefix(*video.ManifestURL, "http://localhost:8091"), "master.m3u8")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/hls/{token}/{path...}", cfg.handlerHLSPlaylist)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get(base + "master.m3u8")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\n360p/index.m3u8\n") {
+		t.Fatalf("master playlist: %d %q", w.Code, w.Body)
+	}
+	w = get(base + "360p/index.m3u8")
+	if w.Code != http.StatusOK {
+		t.Fatalf("rendition playlist: %d %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	for _, segment := range []string{"segment_0000.ts", "segment_0001.ts"} {
+		if want := "\nhttps://tubely.s3.amazonaws.com/hls/v1/360p/" + segment + "\n"; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("rendition playlist %q doesn't link %s presigned", w.Body, segment)
+		}
+	}
+
+	accessToken, err := auth.MakeJWT(uuid.New(), "session", cfg.jwtKeys.keySet(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := auth.MakeHLSToken("hls/v1", auth.NewKeySet(auth.NewHMACKey("other")), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/api/hls/" + accessToken + "/master.m3u8", http.StatusUnauthorized},
+		{"/api/hls/" + otherToken + "/master.m3u8", http.StatusUnauthorized},
+		{base + "360p/segment_0002.json", http.StatusNotFound},
+		{base + "720p/index.m3u8", http.StatusNotFound},
+	} {
+		if w := get(tt.path); w.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+
+	// the mux cleans paths before routing, the handler mustn't rely on it
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetPathValue("token", strings.Split(base, "/")[3])
+	r.SetPathValue("path", "../other/master.m3u8")
+	w = httptest.NewRecorder()
+	cfg.handlerHLSPlaylist(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("playlist outside the token's directory = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
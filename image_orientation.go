@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// exifOrientationTag is the EXIF tag telling how a camera was held, and so
+// how the stored pixels have to be turned to display upright.
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns the EXIF orientation (1 to 8) of a JPEG, PNG or
+// WebP image, or 1 if it has none.
+func exifOrientation(data []byte) int {
+	var exif []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		exif = jpegExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		exif = pngExif(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		exif = webpExif(data)
+	}
+	exif = bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	if o := tiffOrientation(exif); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// jpegExif returns the TIFF data of the APP1 Exif segment of a JPEG.
+func jpegExif(data []byte) []byte {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			i++
+			continue
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			// no payload
+			i += 2
+			continue
+		case marker == 0xDA:
+			// the image data starts, metadata comes before it
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// pngExif returns the eXIf chunk of a PNG.
+func pngExif(data []byte) []byte {
+	i := 8
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			return nil
+		}
+		if kind == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// webpExif returns the EXIF chunk of an extended WebP.
+func webpExif(data []byte) []byte {
+	i := 12
+	for i+8 <= len(data) {
+		kind := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if length < 0 || i+8+length > len(data) {
+			return nil
+		}
+		if kind == "EXIF" {
+			return data[i+8 : i+8+length]
+		}
+		i += 8 + length + length%2
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of EXIF
+// data, or returns 0.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// applyOrientation turns img upright according to an EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored, turned left
+				sx, sy = y, x
+			case 6: // turned left, so turn right
+				sx, sy = y, h-1-x
+			case 7: // mirrored, turned right
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned right, so turn left
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(src.Min.X+sx, src.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegWithOrientation encodes img as a JPEG carrying an EXIF orientation.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// big-endian TIFF with a single IFD holding the orientation
+	tiff := []byte("MM\x00*\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+func TestDecodeThumbnailOrientation(t *testing.T) {
+	// 16x8, the left half red and the right half blue
+	src := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 8 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		orientation uint16
+		width       int
+		height      int
+		// red is where the left half ended up
+		redAt image.Point
+	}{
+		{1, 16, 8, image.Pt(2, 4)},
+		{2, 16, 8, image.Pt(13, 4)},
+		{3, 16, 8, image.Pt(13, 4)},
+		{6, 8, 16, image.Pt(4, 2)},
+		{8, 8, 16, image.Pt(4, 13)},
+	}
+	for _, tt := range tests {
+		data := jpegWithOrientation(t, src, tt.orientation)
+		if got := exifOrientation(data); got != int(tt.orientation) {
+			t.Errorf("exifOrientation = %d, want %d", got, tt.orientation)
+		}
+		img, err := decodeThumbnail(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		r, _, b, _ := img.At(tt.redAt.X, tt.redAt.Y).RGBA()
+		if r < 0xC000 || b > 0x4000 {
+			t.Errorf("orientation %d: pixel at %v isn't red", tt.orientation, tt.redAt)
+		}
+	}
+}
+
+func TestExifOrientationWithoutExif(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := exifOrientation(encoded.Bytes()); got != 1 {
+		t.Errorf("exifOrientation without EXIF = %d, want 1", got)
+	}
+	if got := exifOrientation([]byte("not an image")); got != 1 {
+		t.Errorf("exifOrientation of garbage = %d, want 1", got)
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,6 +19,9 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeHLS grants reading one HLS package, to players that can't
+	// send an Authorization header.
+	TokenTypeHLS TokenType = "tubely-hls"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -38,50 +42,111 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// accessClaims are the claims of an access token. SessionID ties it to the
+// login it was issued for, so ending that session can reject it.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+}
+
+// MakeJWT returns an access token for the user's session, signed with the
+// signing key of keys.
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	sessionID string,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	signingKey := keys.SigningKey()
+	token := jwt.NewWithClaims(signingKey.method(), accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		SessionID: sessionID,
 	})
-	return token.SignedString(signingKey)
+	if signingKey.ID != "" {
+		token.Header["kid"] = signingKey.ID
+	}
+	return token.SignedString(signingKey.signKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+// ValidateJWT returns the user and session an access token was issued to,
+// if it was signed with any key of keys. Tokens from before sessions were
+// recorded in them have an empty sessionID. It returns an error wrapping
+// ErrUnknownKey if the token names a key keys doesn't have.
+func ValidateJWT(tokenString string, keys *KeySet) (userID uuid.UUID, sessionID string, err error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return uuid.Nil, "", errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, claimsStruct.SessionID, nil
+}
+
+// MakeHLSToken returns a token granting read access to the HLS package in
+// the directory dir, signed with the signing key of keys.
+func MakeHLSToken(dir string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	signingKey := keys.SigningKey()
+	token := jwt.NewWithClaims(signingKey.method(), jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeHLS),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   dir,
+	})
+	if signingKey.ID != "" {
+		token.Header["kid"] = signingKey.ID
+	}
+	return token.SignedString(signingKey.signKey)
+}
+
+// ValidateHLSToken returns the directory an HLS token grants access to. Like
+// ValidateJWT, it returns an error wrapping ErrUnknownKey if the token names
+// a key keys doesn't have.
+func ValidateHLSToken(tokenString string, keys *KeySet) (string, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		keys.keyFunc,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(string(TokenTypeHLS)),
+	)
+	if err != nil {
+		return "", err
+	}
+	if claims.ExpiresAt == nil {
+		return "", errors.New("HLS token doesn't expire")
 	}
-	return id, nil
+	if claims.Subject == "" {
+		return "", errors.New("HLS token names no directory")
+	}
+	return claims.Subject, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -118,3 +183,29 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognise.
+const apiKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new random API key.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// APIKeyHint returns the start of an API key, enough for users to tell
+// their keys apart but useless for authenticating.
+func APIKeyHint(key string) string {
+	return key[:min(len(key), len(apiKeyPrefix)+8)]
+}
+
+// HashAPIKey returns what is stored of an API key. Keys are random, so a
+// plain SHA-256 is as good as a slow password hash and can be looked up.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{name: "api key", header: "ApiKey tubely_abc", want: "tubely_abc"},
+		{name: "missing", header: "", wantErr: true},
+		{name: "bearer token", header: "Bearer tubely_abc", wantErr: true},
+		{name: "no key", header: "ApiKey", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.header != "" {
+				headers.Set("Authorization", tt.header)
+			}
+			got, err := GetAPIKey(headers)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("GetAPIKey(%q) = %q, %v; want %q", tt.header, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || key == other {
+		t.Errorf("MakeAPIKey() = %q, %q; want distinct keys starting with %q", key, other, apiKeyPrefix)
+	}
+	if hint := APIKeyHint(key); !strings.HasPrefix(key, hint) || len(hint) != len(apiKeyPrefix)+8 {
+		t.Errorf("APIKeyHint(%q) = %q", key, hint)
+	}
+	if HashAPIKey(key) != HashAPIKey(key) || HashAPIKey(key) == HashAPIKey(other) || strings.Contains(HashAPIKey(key), key) {
+		t.Errorf("HashAPIKey isn't a stable hash of the key")
+	}
+}
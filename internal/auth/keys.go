@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms access tokens can be signed with.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA keys.
+const rsaKeyBits = 2048
+
+var ErrUnknownKey = errors.New("token is signed with an unknown key")
+
+// Key signs or validates access tokens. Its ID goes into the kid header of
+// the tokens it signs.
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey returns an HS256 key for a shared secret. It has no ID, since
+// tokens signed with JWT_SECRET never had a kid.
+func NewHMACKey(secret string) Key {
+	return Key{
+		Algorithm: AlgorithmHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// GenerateKey creates an RS256 or EdDSA key with a random ID. It also
+// returns the private key PEM encoded, for ParseKey.
+func GenerateKey(algorithm string) (Key, string, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, "", fmt.Errorf("can't generate keys for %q", algorithm)
+	}
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return Key{}, "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, "", err
+	}
+	key := Key{
+		ID:        hex.EncodeToString(id),
+		Algorithm: algorithm,
+		signKey:   signer,
+		verifyKey: signer.Public(),
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseKey returns the key with a PEM encoded PKCS #8 private key.
+func ParseKey(id, algorithm, privateKey string) (Key, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return Key{}, errors.New("private key isn't PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return Key{}, err
+	}
+
+	key := Key{ID: id, Algorithm: algorithm}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgorithmRS256 {
+			return Key{}, fmt.Errorf("RSA key can't be used for %q", algorithm)
+		}
+		key.signKey, key.verifyKey = k, k.Public()
+	case ed25519.PrivateKey:
+		if algorithm != AlgorithmEdDSA {
+			return Key{}, fmt.Errorf("Ed25519 key can't be used for %q", algorithm)
+		}
+		key.signKey, key.verifyKey = k, k.Public()
+	default:
+		return Key{}, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	return key, nil
+}
+
+func (k Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet is the keys access tokens are signed and validated with. A KeySet
+// isn't changed once made, so it can be shared between requests.
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeySet returns a set that signs with signing and validates tokens
+// signed by it or any of others.
+func NewKeySet(signing Key, others ...Key) *KeySet {
+	s := &KeySet{
+		signing: signing,
+		keys:    map[string]Key{signing.ID: signing},
+	}
+	for _, k := range others {
+		if _, ok := s.keys[k.ID]; !ok {
+			s.keys[k.ID] = k
+		}
+	}
+	return s
+}
+
+// SigningKey returns the key new tokens are signed with.
+func (s *KeySet) SigningKey() Key {
+	return s.signing
+}
+
+// keyFunc finds the key a token was signed with by its kid. Tokens without
+// one were signed with JWT_SECRET, whose key has no ID either.
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// a token has to use the algorithm of its key, or an RS256 public key
+	// could pass as an HS256 secret
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.Algorithm, token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key as published in a JSON Web Key Set (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are set for Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys of the set, ordered by ID. HS256 keys are
+// secret and left out.
+func (s *KeySet) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, k := range s.keys {
+		jwk := JWK{ID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].ID < jwks[j].ID })
+	return jwks
+}
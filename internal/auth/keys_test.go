@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestValidateJWTKeys(t *testing.T) {
+	rsaKey, _, err := GenerateKey(AlgorithmRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := GenerateKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredKey, _, err := GenerateKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownKey, _, err := GenerateKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewKeySet(edKey, rsaKey, retiredKey)
+	userID := uuid.New()
+
+	sign := func(t *testing.T, keys *KeySet) string {
+		t.Helper()
+		token, err := MakeJWT(userID, "session", keys, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// forge signs a token by hand, to send what MakeJWT never would
+	forge := func(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, accessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    string(TokenTypeAccess),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Subject:   userID.String(),
+			},
+			SessionID: "session",
+		})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(rsaKey.verifyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       func(t *testing.T) string
+		wantErr     bool
+		wantUnknown bool
+	}{
+		{
+			name:  "signing key",
+			token: func(t *testing.T) string { return sign(t, keys) },
+		},
+		{
+			name:  "other key of the set",
+			token: func(t *testing.T) string { return sign(t, NewKeySet(rsaKey)) },
+		},
+		{
+			name:  "retired key",
+			token: func(t *testing.T) string { return sign(t, NewKeySet(retiredKey)) },
+		},
+		{
+			name:        "unknown kid",
+			token:       func(t *testing.T) string { return sign(t, NewKeySet(unknownKey)) },
+			wantErr:     true,
+			wantUnknown: true,
+		},
+		{
+			name:        "no kid without a JWT_SECRET key",
+			token:       func(t *testing.T) string { return sign(t, NewKeySet(NewHMACKey("secret"))) },
+			wantErr:     true,
+			wantUnknown: true,
+		},
+		{
+			name: "HS256 with the RSA public key as secret",
+			token: func(t *testing.T) string {
+				return forge(t, jwt.SigningMethodHS256, rsaKey.ID, rsaPublic)
+			},
+			wantErr: true,
+		},
+		{
+			name: "RS256 kid on an EdDSA key",
+			token: func(t *testing.T) string {
+				return forge(t, jwt.SigningMethodRS256, edKey.ID, rsaKey.signKey)
+			},
+			wantErr: true,
+		},
+		{
+			name: "alg none",
+			token: func(t *testing.T) string {
+				return forge(t, jwt.SigningMethodNone, edKey.ID, jwt.UnsafeAllowNoneSignatureType)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, _, err := ValidateJWT(tt.token(t), keys)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ValidateJWT() succeeded, want an error")
+				}
+				if errors.Is(err, ErrUnknownKey) != tt.wantUnknown {
+					t.Errorf("ValidateJWT() = %v, ErrUnknownKey %v; want %v", err, !tt.wantUnknown, tt.wantUnknown)
+				}
+				return
+			}
+			if err != nil || gotUserID != userID {
+				t.Errorf("ValidateJWT() = %v, %v; want %v", gotUserID, err, userID)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedKeyPrefix marks private keys encrypted by SealPrivateKey, as opposed
+// to the PEM that GenerateKey returns.
+const sealedKeyPrefix = "aes256gcm:"
+
+// ParseEncryptionKey decodes a base64 encoded 32-byte key, as generated by
+// `openssl rand -base64 32`, to encrypt private keys with.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key isn't base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key is %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// PrivateKeySealed reports whether privateKey was encrypted by
+// SealPrivateKey.
+func PrivateKeySealed(privateKey string) bool {
+	return strings.HasPrefix(privateKey, sealedKeyPrefix)
+}
+
+// SealPrivateKey encrypts the private key of the key with the given ID with
+// AES-256-GCM. The ID is authenticated along with it, so a sealed key can't
+// be passed off as another.
+func SealPrivateKey(id, privateKey string, encryptionKey []byte) (string, error) {
+	aead, err := newKeyAEAD(encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(privateKey), []byte(id))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenPrivateKey decrypts a private key sealed by SealPrivateKey.
+func OpenPrivateKey(id, sealed string, encryptionKey []byte) (string, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealedKeyPrefix)
+	if !ok {
+		return "", errors.New("private key isn't encrypted")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newKeyAEAD(encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("encrypted private key is truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	privateKey, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", errors.New("couldn't decrypt private key, check the encryption key")
+	}
+	return string(privateKey), nil
+}
+
+func newKeyAEAD(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSealPrivateKey(t *testing.T) {
+	encryptionKey := bytes.Repeat([]byte("k"), 32)
+	_, privateKey, err := GenerateKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealPrivateKey("key-1", privateKey, encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !PrivateKeySealed(sealed) || PrivateKeySealed(privateKey) || strings.Contains(sealed, "PRIVATE KEY") {
+		t.Fatalf("SealPrivateKey() = %q, want an encrypted key", sealed)
+	}
+	if again, _ := SealPrivateKey("key-1", privateKey, encryptionKey); again == sealed {
+		t.Errorf("sealing twice gave the same ciphertext, nonces aren't random")
+	}
+
+	tests := []struct {
+		name          string
+		id            string
+		sealed        string
+		encryptionKey []byte
+		wantErr       bool
+	}{
+		{name: "same ID and key", id: "key-1", sealed: sealed, encryptionKey: encryptionKey},
+		{name: "another ID", id: "key-2", sealed: sealed, encryptionKey: encryptionKey, wantErr: true},
+		{name: "another encryption key", id: "key-1", sealed: sealed, encryptionKey: bytes.Repeat([]byte("x"), 32), wantErr: true},
+		{name: "tampered", id: "key-1", sealed: sealed[:len(sealed)-4] + "AAAA", encryptionKey: encryptionKey, wantErr: true},
+		{name: "truncated", id: "key-1", sealed: sealedKeyPrefix + "AAAA", encryptionKey: encryptionKey, wantErr: true},
+		{name: "not sealed", id: "key-1", sealed: privateKey, encryptionKey: encryptionKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenPrivateKey(tt.id, tt.sealed, tt.encryptionKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("OpenPrivateKey() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil || got != privateKey {
+				t.Errorf("OpenPrivateKey() = %q, %v; want the sealed key", got, err)
+			}
+		})
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		wantErr bool
+	}{
+		{s: "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s=\n"},
+		{s: "a2tra2tr", wantErr: true},
+		{s: "not base64!", wantErr: true},
+	} {
+		if _, err := ParseEncryptionKey(tt.s); (err != nil) != tt.wantErr {
+			t.Errorf("ParseEncryptionKey(%q) = %v, want error %v", tt.s, err, tt.wantErr)
+		}
+	}
+}
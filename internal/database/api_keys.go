@@ -0,0 +1,194 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyScope is something an API key may be used for.
+type APIKeyScope string
+
+const (
+	APIKeyScopeRead   APIKeyScope = "read"
+	APIKeyScopeUpload APIKeyScope = "upload"
+	APIKeyScopeDelete APIKeyScope = "delete"
+)
+
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case APIKeyScopeRead, APIKeyScopeUpload, APIKeyScopeDelete:
+		return true
+	}
+	return false
+}
+
+// APIKeyScopes is stored as a JSON array.
+type APIKeyScopes []APIKeyScope
+
+func (s *APIKeyScopes) Scan(src any) error {
+	return scanJSON(src, s)
+}
+
+func (s APIKeyScopes) Value() (driver.Value, error) {
+	if s == nil {
+		s = APIKeyScopes{}
+	}
+	return valueJSON(s)
+}
+
+// Has reports whether the scopes include any of want.
+func (s APIKeyScopes) Has(want ...APIKeyScope) bool {
+	for _, have := range s {
+		for _, w := range want {
+			if have == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// APIKey is a stored API key. The key itself is only known when it is
+// created.
+type APIKey struct {
+	ID         uuid.UUID    `json:"id"`
+	UserID     uuid.UUID    `json:"user_id"`
+	Name       string       `json:"name"`
+	KeyHash    string       `json:"-"`
+	Prefix     string       `json:"prefix"`
+	Scopes     APIKeyScopes `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ExpiresAt  *time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+	RevokedAt  *time.Time   `json:"revoked_at"`
+}
+
+type CreateAPIKeyParams struct {
+	UserID    uuid.UUID
+	Name      string
+	KeyHash   string
+	Prefix    string
+	Scopes    APIKeyScopes
+	ExpiresAt *time.Time
+}
+
+const apiKeyColumns = `
+		id,
+		user_id,
+		name,
+		key_hash,
+		prefix,
+		scopes,
+		created_at,
+		expires_at,
+		last_used_at,
+		revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.KeyHash,
+		&key.Prefix,
+		&key.Scopes,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (id, user_id, name, key_hash, prefix, scopes, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		utc := params.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	_, err := c.exec(query, id, params.UserID, params.Name, params.KeyHash, params.Prefix, params.Scopes, expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return scanAPIKey(c.queryRow(`SELECT`+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+}
+
+// GetAPIKeyByHash returns the key with the given hash, or ErrNotFound. It
+// doesn't check whether the key is revoked or expired.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	key, err := scanAPIKey(c.queryRow(`SELECT`+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+// ListAPIKeys returns the keys of a user that aren't revoked, newest first.
+// Expired keys are included.
+func (c Client) ListAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey returns ErrNotFound if the user has no such key that isn't
+// revoked yet.
+func (c Client) RevokeAPIKey(userID, id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	res, err := c.exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used at now. To spare a write per
+// request, last_used_at only moves forward once it is a minute old.
+func (c Client) TouchAPIKey(id uuid.UUID, now time.Time) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = ?
+	WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	now = now.UTC()
+	_, err := c.exec(query, now, id, now.Add(-time.Minute))
+	return err
+}
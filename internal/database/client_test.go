@@ -0,0 +1,675 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func migratedTestClient(t *testing.T, c Client) {
+	t.Helper()
+	if err := c.Migrate(MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestVideo(t *testing.T, c Client) (*User, Video) {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "Boots", Description: "A video", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, video
+}
+
+func TestUsersAndRefreshTokens(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+
+		user, err := c.CreateUser(CreateUserParams{Email: "boots@example.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetUserByEmail("boots@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID {
+			t.Errorf("GetUserByEmail returned user %s, want %s", got.ID, user.ID)
+		}
+
+		_, err = c.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		byToken, err := c.GetUserByRefreshToken("token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byToken.ID != user.ID {
+			t.Errorf("GetUserByRefreshToken = %v, want user %s", byToken, user.ID)
+		}
+
+		if _, err := c.CreateUser(CreateUserParams{Email: "boots@example.com", Password: "hash"}); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("CreateUser with a taken email = %v, want ErrDuplicateEmail", err)
+		}
+		if _, err := c.GetUserByEmail("nobody@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByEmail of an unknown email = %v, want ErrNotFound", err)
+		}
+		if _, err := c.GetUserByRefreshToken("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByRefreshToken of an unknown token = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestVideos(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, video := createTestVideo(t, c)
+
+		if video.Status != VideoStatusDraft {
+			t.Errorf("new video has status %s, want draft", video.Status)
+		}
+		video.VideoObject = &ObjectRef{Backend: "s3", Bucket: "tubely", Key: "landscape/abc.mp4"}
+		video.ThumbnailObjects = ThumbnailRefs{160: {Backend: "local", Key: "abc/160.jpeg"}}
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SetVideoStatus(video.ID, VideoStatusProcessing, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SetVideoStatus(video.ID, VideoStatusDraft, ""); err == nil {
+			t.Error("processing -> draft succeeded, want ErrInvalidTransition")
+		}
+
+		videos, _, err := c.ListVideos(ListVideosParams{UserID: user.ID, Sort: VideoSortCreated, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(videos) != 1 {
+			t.Fatalf("ListVideos returned %d videos, want 1", len(videos))
+		}
+		got := videos[0]
+		if got.VideoObject == nil || *got.VideoObject != *video.VideoObject {
+			t.Errorf("VideoObject = %v, want %v", got.VideoObject, video.VideoObject)
+		}
+		if got.ThumbnailObjects[160].Key != "abc/160.jpeg" {
+			t.Errorf("ThumbnailObjects = %v", got.ThumbnailObjects)
+		}
+		if got.Status != VideoStatusProcessing || got.ProcessingAt == nil {
+			t.Errorf("status %s, processing_at %v; want processing with a timestamp", got.Status, got.ProcessingAt)
+		}
+
+		if ok, err := c.TrashVideo(video.ID); err != nil || !ok {
+			t.Fatalf("TrashVideo = %v, %v", ok, err)
+		}
+		if _, err := c.GetVideo(video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetVideo of a trashed video = %v, want ErrNotFound", err)
+		}
+		trashed, err := c.GetVideosTrashedBefore(time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trashed) != 1 {
+			t.Errorf("GetVideosTrashedBefore returned %d videos, want 1", len(trashed))
+		}
+		if ok, err := c.RestoreVideo(video.ID); err != nil || !ok {
+			t.Fatalf("RestoreVideo = %v, %v", ok, err)
+		}
+
+		err = c.UpsertMediaInfo(MediaInfo{VideoID: video.ID, Container: "mov,mp4", Duration: 1.5, VideoCodec: "h264", Width: 1920, Height: 1080})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.UpsertMediaInfo(MediaInfo{VideoID: video.ID, Container: "mov,mp4", Duration: 2.5, VideoCodec: "h264", Width: 1920, Height: 1080})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := c.GetMediaInfo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info == nil || info.Duration != 2.5 {
+			t.Errorf("GetMediaInfo = %+v, want the second upsert", info)
+		}
+
+		job, err := c.PurgeVideo(video.ID, CreateJobParams{Type: "delete_objects", VideoID: video.ID, Payload: "{}", MaxAttempts: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != JobStatusQueued || job.VideoID != video.ID {
+			t.Errorf("PurgeVideo queued %+v", job)
+		}
+		if _, err := c.GetVideoIncludingTrashed(video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetVideoIncludingTrashed after purge = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestUploadSessions(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, video := createTestVideo(t, c)
+
+		session, err := c.CreateUploadSession(CreateUploadSessionParams{
+			VideoID:   video.ID,
+			UserID:    user.ID,
+			Length:    100,
+			FilePath:  "/tmp/upload",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := c.AdvanceUploadSession(session.ID, 0, 50); err != nil || !ok {
+			t.Fatalf("AdvanceUploadSession(0, 50) = %v, %v", ok, err)
+		}
+		if ok, err := c.AdvanceUploadSession(session.ID, 0, 60); err != nil || ok {
+			t.Errorf("AdvanceUploadSession from a stale offset = %v, %v; want false", ok, err)
+		}
+		got, err := c.GetUploadSession(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Offset != 50 {
+			t.Errorf("offset = %d, want 50", got.Offset)
+		}
+
+		if expired, err := c.ListExpiredUploadSessions(time.Now()); err != nil || len(expired) != 0 {
+			t.Errorf("ListExpiredUploadSessions = %v, %v; want none", expired, err)
+		}
+		expired, err := c.ListExpiredUploadSessions(time.Now().Add(2 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expired) != 1 || expired[0].ID != session.ID || expired[0].FilePath != "/tmp/upload" {
+			t.Errorf("ListExpiredUploadSessions after expiry = %+v, want the session", expired)
+		}
+	})
+}
+
+func TestJobs(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		_, video := createTestVideo(t, c)
+
+		job, err := c.CreateJob(CreateJobParams{Type: "process_video", VideoID: video.ID, Payload: "{}", MaxAttempts: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claimed, err := c.ClaimJob(time.Now().Add(time.Second), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed == nil || claimed.ID != job.ID || claimed.Attempts != 1 {
+			t.Fatalf("ClaimJob = %+v, want job %s on its first attempt", claimed, job.ID)
+		}
+		if again, err := c.ClaimJob(time.Now().Add(time.Second), time.Now().Add(time.Minute)); err != nil || again != nil {
+			t.Errorf("second ClaimJob = %v, %v; want nothing due", again, err)
+		}
+
+		if err := c.RetryJob(job.ID, time.Now().Add(-time.Second), "boom"); err != nil {
+			t.Fatal(err)
+		}
+		claimed, err = c.ClaimJob(time.Now(), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed == nil || claimed.LastError == nil || *claimed.LastError != "boom" {
+			t.Fatalf("ClaimJob after retry = %+v", claimed)
+		}
+		// another instance doesn't take a job whose lease is still held
+		if n, err := c.RequeueExpiredJobs(time.Now()); err != nil || n != 0 {
+			t.Errorf("RequeueExpiredJobs before the lease expired = %d, %v; want 0", n, err)
+		}
+		if err := c.ExtendJobLease(claimed.ID, time.Now().Add(2*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := c.RequeueExpiredJobs(time.Now().Add(90 * time.Second)); err != nil || n != 0 {
+			t.Errorf("RequeueExpiredJobs before the extended lease expired = %d, %v; want 0", n, err)
+		}
+		if n, err := c.RequeueExpiredJobs(time.Now().Add(3 * time.Minute)); err != nil || n != 1 {
+			t.Errorf("RequeueExpiredJobs after the lease expired = %d, %v; want 1", n, err)
+		}
+		if err := c.ExtendJobLease(claimed.ID, time.Now().Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ExtendJobLease of a requeued job = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestListVideos(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, err := c.CreateUser(CreateUserParams{Email: "boots@example.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// titles and durations (the index) sort in different orders
+		titles := []string{"delta", "Alpha", "echo", "charlie", "bravo"}
+		byTitle := map[string]Video{}
+		for i, title := range titles {
+			video, err := c.CreateVideo(CreateVideoParams{Title: title, UserID: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			byTitle[title] = video
+			// alpha and bravo are portrait, the others landscape
+			width, height := 1920, 1080
+			if title == "Alpha" || title == "bravo" {
+				width, height = 1080, 1920
+			}
+			err = c.UpsertMediaInfo(MediaInfo{VideoID: video.ID, Container: "mov,mp4", Duration: float64(i), VideoCodec: "h264", Width: width, Height: height})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		withVideo := byTitle["echo"]
+		withVideo.VideoObject = &ObjectRef{Backend: "s3", Bucket: "tubely", Key: "echo.mp4"}
+		if err := c.UpdateVideo(withVideo); err != nil {
+			t.Fatal(err)
+		}
+
+		titlesOf := func(videos []Video) []string {
+			got := []string{}
+			for _, v := range videos {
+				got = append(got, v.Title)
+			}
+			return got
+		}
+		listAll := func(params ListVideosParams) []string {
+			t.Helper()
+			params.UserID = user.ID
+			all := []Video{}
+			for {
+				page, next, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				all = append(all, page...)
+				if next == nil {
+					return titlesOf(all)
+				}
+				params.After = next
+			}
+		}
+		equal := func(a, b []string) bool {
+			return strings.Join(a, ",") == strings.Join(b, ",")
+		}
+
+		if got, want := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 2}), []string{"Alpha", "bravo", "charlie", "delta", "echo"}; !equal(got, want) {
+			t.Errorf("by title = %v, want %v", got, want)
+		}
+		if got, want := listAll(ListVideosParams{Sort: VideoSortDuration, Descending: true, Limit: 2}), []string{"bravo", "charlie", "echo", "Alpha", "delta"}; !equal(got, want) {
+			t.Errorf("by duration = %v, want %v", got, want)
+		}
+		if got := listAll(ListVideosParams{Sort: VideoSortCreated, Descending: true, Limit: 3}); len(got) != 5 {
+			t.Errorf("by created returned %v, want all 5 videos", got)
+		}
+
+		if got, want := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, Orientation: OrientationPortrait}), []string{"Alpha", "bravo"}; !equal(got, want) {
+			t.Errorf("portrait = %v, want %v", got, want)
+		}
+		hasVideo := true
+		if got, want := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, HasVideo: &hasVideo}), []string{"echo"}; !equal(got, want) {
+			t.Errorf("has_video = %v, want %v", got, want)
+		}
+		if got := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, Statuses: []VideoStatus{VideoStatusReady}}); len(got) != 0 {
+			t.Errorf("status=ready = %v, want none", got)
+		}
+		future := time.Now().Add(time.Hour)
+		if got := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, CreatedAfter: &future}); len(got) != 0 {
+			t.Errorf("created_after an hour from now = %v, want none", got)
+		}
+		if got := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, CreatedBefore: &future}); len(got) != 5 {
+			t.Errorf("created_before an hour from now = %v, want all 5 videos", got)
+		}
+		createdAt := byTitle["delta"].CreatedAt
+		if got := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, CreatedAfter: &createdAt}); !slices.Contains(got, "delta") {
+			t.Errorf("created_after the creation time of delta = %v, want delta included", got)
+		}
+		if got := listAll(ListVideosParams{Sort: VideoSortTitle, Limit: 10, CreatedBefore: &createdAt}); slices.Contains(got, "delta") {
+			t.Errorf("created_before the creation time of delta = %v, want delta excluded", got)
+		}
+
+		// the cursor keeps its place when the video it ended on changes
+		params := ListVideosParams{UserID: user.ID, Sort: VideoSortTitle, Limit: 2}
+		page, next, err := c.ListVideos(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(page); !equal(got, []string{"Alpha", "bravo"}) {
+			t.Fatalf("first page by title = %v", got)
+		}
+		renamed := byTitle["bravo"]
+		renamed.Title = "zulu"
+		if err := c.UpdateVideo(renamed); err != nil {
+			t.Fatal(err)
+		}
+		params.After = next
+		if page, _, err = c.ListVideos(params); err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(page); !equal(got, []string{"charlie", "delta"}) {
+			t.Errorf("page after renaming its anchor = %v, want charlie and delta", got)
+		}
+		if err := c.DeleteVideo(renamed.ID); err != nil {
+			t.Fatal(err)
+		}
+		if page, _, err = c.ListVideos(params); err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(page); !equal(got, []string{"charlie", "delta"}) {
+			t.Errorf("page after deleting its anchor = %v, want charlie and delta", got)
+		}
+	})
+}
+
+func TestUpdateVideoConflict(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		_, video := createTestVideo(t, c)
+		if video.Version != 1 {
+			t.Fatalf("new video has version %d, want 1", video.Version)
+		}
+
+		// backdate updated_at; CURRENT_TIMESTAMP only has whole seconds
+		if _, err := c.exec("UPDATE videos SET updated_at = ? WHERE id = ?", time.Now().Add(-time.Hour).UTC(), video.ID); err != nil {
+			t.Fatal(err)
+		}
+		stale := video
+		video.Title = "Boots returns"
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		stale.Description = "Lost edit"
+		if err := c.UpdateVideo(stale); !errors.Is(err, ErrConflict) {
+			t.Errorf("UpdateVideo of a stale video = %v, want ErrConflict", err)
+		}
+
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Boots returns" || got.Description != video.Description {
+			t.Errorf("video = %q, %q; want only the first update", got.Title, got.Description)
+		}
+		if got.Version != 2 {
+			t.Errorf("version = %d, want 2", got.Version)
+		}
+		if time.Since(got.UpdatedAt) > time.Minute {
+			t.Errorf("updated_at = %v, want about now", got.UpdatedAt)
+		}
+
+		if err := c.SetVideoStatus(video.ID, VideoStatusUploading, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.UpdateVideo(got); !errors.Is(err, ErrConflict) {
+			t.Errorf("UpdateVideo after a status change = %v, want ErrConflict", err)
+		}
+
+		if err := c.DeleteVideo(video.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.UpdateVideo(got); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateVideo of a deleted video = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, _ := createTestVideo(t, c)
+		expires := time.Now().Add(time.Hour)
+
+		first, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expires, FamilyID: "family"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := c.RotateRefreshToken(first.Token, CreateRefreshTokenParams{Token: "second", UserID: user.ID, ExpiresAt: expires})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.FamilyID != "family" || second.RevokedAt != nil {
+			t.Errorf("rotated token has family %q, revoked at %v; want family, not revoked", second.FamilyID, second.RevokedAt)
+		}
+		if _, err := c.RotateRefreshToken(first.Token, CreateRefreshTokenParams{Token: "third", UserID: user.ID, ExpiresAt: expires}); !errors.Is(err, ErrConflict) {
+			t.Errorf("rotating a revoked token = %v, want ErrConflict", err)
+		}
+
+		other, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "other", UserID: user.ID, ExpiresAt: expires, FamilyID: "other family"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.RevokeRefreshTokenFamily("family"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := c.GetRefreshToken(second.Token); err != nil || got.RevokedAt == nil {
+			t.Errorf("token of a revoked family = %+v, %v; want revoked", got, err)
+		}
+		if got, err := c.GetRefreshToken(other.Token); err != nil || got.RevokedAt != nil {
+			t.Errorf("token of another family = %+v, %v; want not revoked", got, err)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, _ := createTestVideo(t, c)
+		other, _ := createTestVideo(t, c)
+		expires := time.Now().UTC().Add(time.Hour)
+
+		login := func(userID uuid.UUID, userAgent string) Session {
+			t.Helper()
+			session, err := c.CreateSession(CreateSessionParams{UserID: userID, UserAgent: userAgent, IP: "127.0.0.1", RefreshToken: uuid.NewString(), RefreshTokenExpiresAt: expires})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return session
+		}
+		laptop := login(user.ID, "laptop")
+		phone := login(user.ID, "phone")
+		login(other.ID, "other")
+
+		if err := c.TouchSession(phone.ID, "phone 2", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		sessions, err := c.ListSessions(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("ListSessions returned %d sessions, want 2", len(sessions))
+		}
+		for _, s := range sessions {
+			if s.ID == phone.ID && (s.UserAgent != "phone 2" || s.IP != "10.0.0.1") {
+				t.Errorf("touched session = %+v, want the new user agent and IP", s)
+			}
+		}
+
+		if err := c.RevokeSession(other.ID, laptop.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevokeSession of another user's session = %v, want ErrNotFound", err)
+		}
+		if err := c.RevokeSession(user.ID, laptop.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.RevokeSession(user.ID, laptop.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("revoking a session twice = %v, want ErrNotFound", err)
+		}
+		if sessions, _ := c.ListSessions(user.ID); len(sessions) != 1 || sessions[0].ID != phone.ID {
+			t.Errorf("sessions after revoking the laptop = %+v, want the phone", sessions)
+		}
+		for _, tt := range []struct {
+			userID uuid.UUID
+			id     string
+			want   bool
+		}{
+			{user.ID, phone.ID, true},
+			{user.ID, laptop.ID, false},
+			{other.ID, phone.ID, false},
+		} {
+			if active, err := c.SessionActive(tt.userID, tt.id); err != nil || active != tt.want {
+				t.Errorf("SessionActive(%s, %s) = %v, %v; want %v", tt.userID, tt.id, active, err, tt.want)
+			}
+		}
+
+		if err := c.RevokeAllSessions(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if sessions, _ := c.ListSessions(user.ID); len(sessions) != 0 {
+			t.Errorf("sessions after logging out everywhere = %+v, want none", sessions)
+		}
+		if sessions, _ := c.ListSessions(other.ID); len(sessions) != 1 {
+			t.Errorf("other user's sessions = %+v, want 1", sessions)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, _ := createTestVideo(t, c)
+		other, _ := createTestVideo(t, c)
+		expires := time.Now().Add(time.Hour)
+
+		key, err := c.CreateAPIKey(CreateAPIKeyParams{
+			UserID:    user.ID,
+			Name:      "CI",
+			KeyHash:   "hash",
+			Prefix:    "tubely_abcdefgh",
+			Scopes:    APIKeyScopes{APIKeyScopeRead, APIKeyScopeUpload},
+			ExpiresAt: &expires,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetAPIKeyByHash("hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != key.ID || !got.Scopes.Has(APIKeyScopeUpload) || got.Scopes.Has(APIKeyScopeDelete) {
+			t.Errorf("GetAPIKeyByHash = %+v, want the read and upload key", got)
+		}
+		if _, err := c.GetAPIKeyByHash("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetAPIKeyByHash of an unknown hash = %v, want ErrNotFound", err)
+		}
+
+		now := time.Now()
+		if err := c.TouchAPIKey(key.ID, now); err != nil {
+			t.Fatal(err)
+		}
+		// within a minute of the last use, nothing is written
+		if err := c.TouchAPIKey(key.ID, now.Add(30*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = c.GetAPIKeyByHash("hash")
+		if got.LastUsedAt == nil || got.LastUsedAt.Sub(now).Abs() > time.Second {
+			t.Errorf("last_used_at = %v, want %v", got.LastUsedAt, now)
+		}
+
+		if err := c.RevokeAPIKey(other.ID, key.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevokeAPIKey of another user's key = %v, want ErrNotFound", err)
+		}
+		if keys, err := c.ListAPIKeys(user.ID); err != nil || len(keys) != 1 {
+			t.Fatalf("ListAPIKeys = %v, %v; want the key", keys, err)
+		}
+		if err := c.RevokeAPIKey(user.ID, key.ID); err != nil {
+			t.Fatal(err)
+		}
+		if keys, err := c.ListAPIKeys(user.ID); err != nil || len(keys) != 0 {
+			t.Errorf("ListAPIKeys after revoking = %v, %v; want none", keys, err)
+		}
+	})
+}
+
+func TestSigningKeys(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		start := time.Now()
+
+		first, err := c.CreateSigningKey(CreateSigningKeyParams{ID: "first", Algorithm: "EdDSA", PrivateKey: "pem"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.RetiredAt != nil {
+			t.Errorf("new key is retired at %v", first.RetiredAt)
+		}
+		if _, err := c.CreateSigningKey(CreateSigningKeyParams{ID: "second", Algorithm: "RS256", PrivateKey: "pem"}); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := c.ListSigningKeys(start.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[0].ID != "second" || keys[0].RetiredAt != nil || keys[1].RetiredAt == nil {
+			t.Fatalf("ListSigningKeys = %+v, want second current and first retired", keys)
+		}
+
+		// the first key was retired within the last minute, so it's out of
+		// a window that starts later
+		keys, err = c.ListSigningKeys(time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0].ID != "second" {
+			t.Errorf("ListSigningKeys after the grace window = %+v, want only second", keys)
+		}
+
+		if err := c.DeleteSigningKeysRetiredBefore(time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		keys, err = c.ListSigningKeys(start.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0].ID != "second" {
+			t.Errorf("ListSigningKeys after deleting retired keys = %+v, want only second", keys)
+		}
+	})
+}
+
+func TestObjectKeyReferenced(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		_, video := createTestVideo(t, c)
+		video.VideoObject = &ObjectRef{Backend: "s3", Bucket: "tubely", Key: "landscape/abc.mp4"}
+		video.ManifestObject = &ObjectRef{Backend: "s3", Bucket: "tubely", Key: "landscape/a_c/master.m3u8"}
+		video.ThumbnailObjects = ThumbnailRefs{160: {Backend: "local", Key: "thumbnails/x1/160.jpg"}}
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := c.TrashVideo(video.ID); err != nil || !ok {
+			t.Fatalf("TrashVideo = %v, %v", ok, err)
+		}
+
+		for key, want := range map[string]bool{
+			"landscape/abc.mp4":             true,
+			"landscape/a_c/720p/index.m3u8": true,
+			"thumbnails/x1/160.jpg":         true,
+			"landscape/other.mp4":           false,
+			"landscape/a_cd/master.m3u8":    false,
+			// _ must not act as a wildcard
+			"landscape/abc/720p/index.m3u8": false,
+			"landscape":                     false,
+		} {
+			got, err := c.ObjectKeyReferenced(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("ObjectKeyReferenced(%q) = %v, want %v", key, got, want)
+			}
+		}
+	})
+}
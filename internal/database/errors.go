@@ -0,0 +1,33 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned when the row asked for doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write is based on a version of a row
+	// that is no longer the stored one.
+	ErrConflict = errors.New("changed concurrently")
+	// ErrDuplicateEmail is returned when creating a user with an email
+	// another user already has.
+	ErrDuplicateEmail = errors.New("email already in use")
+)
+
+// isUniqueViolation reports whether err is a unique constraint failing, on
+// either engine.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+type Job struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Status      JobStatus  `json:"status"`
+	Attempts    int        `json:"attempts"`
+	RunAt       time.Time  `json:"run_at"`
+	LastError   *string    `json:"last_error"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Type        string    `json:"type"`
+	VideoID     uuid.UUID `json:"video_id"`
+	Payload     string    `json:"-"`
+	MaxAttempts int       `json:"max_attempts"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		video_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error,
+		completed_at`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.VideoID,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.CompletedAt,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	var id uuid.UUID
+	err := c.inTx(func(tx *sql.Tx) error {
+		var err error
+		id, err = c.insertJob(tx, params)
+		return err
+	})
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) insertJob(tx *sql.Tx, params CreateJobParams) (uuid.UUID, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		video_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := tx.Exec(c.rebind(query), id, params.Type, params.VideoID, params.Payload, JobStatusQueued, params.MaxAttempts, time.Now().UTC())
+	return id, err
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, ErrNotFound
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest due job as running, leased until lockedUntil,
+// and returns it, or nil when nothing is due.
+func (c Client) ClaimJob(now, lockedUntil time.Time) (*Job, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		locked_until = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+	) AND status = ?
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.queryRow(query, JobStatusRunning, lockedUntil.UTC(), JobStatusQueued, now.UTC(), JobStatusQueued))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtendJobLease keeps a running job leased until lockedUntil. It returns
+// ErrNotFound when the job isn't running anymore, e.g. because its lease
+// expired and it was requeued.
+func (c Client) ExtendJobLease(id uuid.UUID, lockedUntil time.Time) error {
+	query := `
+	UPDATE jobs
+	SET locked_until = ?
+	WHERE id = ? AND status = ?
+	`
+	res, err := c.exec(query, lockedUntil.UTC(), id, JobStatusRunning)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = NULL,
+		locked_until = NULL,
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusSucceeded, id)
+	return err
+}
+
+// RetryJob puts a failed attempt back in the queue to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, reason string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		run_at = ?,
+		last_error = ?,
+		locked_until = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusQueued, runAt.UTC(), reason, id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, reason string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		locked_until = NULL,
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusFailed, reason, id)
+	return err
+}
+
+// RequeueExpiredJobs returns running jobs whose lease expired before now to
+// the queue: the instance running them is gone. Jobs claimed before leases
+// existed have none and are requeued too.
+func (c Client) RequeueExpiredJobs(now time.Time) (int64, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		run_at = ?,
+		locked_until = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND (locked_until IS NULL OR locked_until < ?)
+	`
+	res, err := c.exec(query, JobStatusQueued, now.UTC(), JobStatusRunning, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaInfo describes the uploaded source file of a video.
+type MediaInfo struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Container string    `json:"container"`
+	Size      int64     `json:"size"`     // bytes
+	Duration  float64   `json:"duration"` // seconds
+	Bitrate   int64     `json:"bitrate"`  // bit/s
+	// VideoCodec, Width, Height and FrameRate describe the first video
+	// stream. Width and Height are as stored, before Rotation is applied.
+	VideoCodec string  `json:"video_codec"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"frame_rate"`
+	// Rotation is the clockwise rotation in degrees players apply on display.
+	Rotation int `json:"rotation"`
+	// The audio fields are nil for videos without an audio stream.
+	AudioCodec      *string `json:"audio_codec"`
+	AudioChannels   *int    `json:"audio_channels"`
+	AudioSampleRate *int    `json:"audio_sample_rate"`
+}
+
+// UpsertMediaInfo stores info, replacing what was stored for the same video.
+func (c Client) UpsertMediaInfo(info MediaInfo) error {
+	query := `
+	INSERT INTO media_info (
+		video_id,
+		created_at,
+		updated_at,
+		container,
+		size,
+		duration,
+		bitrate,
+		video_codec,
+		width,
+		height,
+		frame_rate,
+		rotation,
+		audio_codec,
+		audio_channels,
+		audio_sample_rate
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		container = excluded.container,
+		size = excluded.size,
+		duration = excluded.duration,
+		bitrate = excluded.bitrate,
+		video_codec = excluded.video_codec,
+		width = excluded.width,
+		height = excluded.height,
+		frame_rate = excluded.frame_rate,
+		rotation = excluded.rotation,
+		audio_codec = excluded.audio_codec,
+		audio_channels = excluded.audio_channels,
+		audio_sample_rate = excluded.audio_sample_rate
+	`
+	_, err := c.exec(
+		query,
+		info.VideoID,
+		info.Container,
+		info.Size,
+		info.Duration,
+		info.Bitrate,
+		info.VideoCodec,
+		info.Width,
+		info.Height,
+		info.FrameRate,
+		info.Rotation,
+		info.AudioCodec,
+		info.AudioChannels,
+		info.AudioSampleRate,
+	)
+	return err
+}
+
+// GetMediaInfo returns nil if nothing has been stored for the video yet.
+func (c Client) GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error) {
+	query := `
+	SELECT
+		video_id,
+		created_at,
+		updated_at,
+		container,
+		size,
+		duration,
+		bitrate,
+		video_codec,
+		width,
+		height,
+		frame_rate,
+		rotation,
+		audio_codec,
+		audio_channels,
+		audio_sample_rate
+	FROM media_info
+	WHERE video_id = ?
+	`
+
+	var info MediaInfo
+	err := c.queryRow(query, videoID).Scan(
+		&info.VideoID,
+		&info.CreatedAt,
+		&info.UpdatedAt,
+		&info.Container,
+		&info.Size,
+		&info.Duration,
+		&info.Bitrate,
+		&info.VideoCodec,
+		&info.Width,
+		&info.Height,
+		&info.FrameRate,
+		&info.Rotation,
+		&info.AudioCodec,
+		&info.AudioChannels,
+		&info.AudioSampleRate,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &info, nil
+}
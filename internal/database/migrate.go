@@ -0,0 +1,321 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql and are compiled into the binary. Both dialects use the
+// same version numbers. Each migration runs in its own transaction together
+// with the schema_migrations row recording it.
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var schemaMigrationsTable = map[Dialect]string{
+	DialectSQLite: `
+	CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)
+	`,
+	DialectPostgres: `
+	CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)
+	`,
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// upFunc runs after Up, for conversions SQL can't do.
+	upFunc func(tx *sql.Tx, c Client, opts MigrateOptions) error
+}
+
+// goMigrations are the migrations written in Go. They have no SQL files, and
+// rolling one back leaves the data as it is.
+var goMigrations = []Migration{
+	{Version: 9, Name: "legacy_url_refs", upFunc: convertLegacyVideoURLs},
+}
+
+// MigrateOptions carries what migrations that convert existing data need
+// from the application.
+type MigrateOptions struct {
+	// LegacyObjectRef works out which object a URL stored by older versions
+	// points at.
+	LegacyObjectRef func(url string) (*ObjectRef, bool)
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var ErrNoMigrationToRollBack = errors.New("no migration has been applied")
+
+func loadMigrations(dialect Dialect) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, path.Join("migrations", string(dialect), "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", base)
+		}
+		number, name, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", base)
+		}
+
+		contents, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	for _, m := range goMigrations {
+		if _, ok := byVersion[m.Version]; ok {
+			return nil, fmt.Errorf("migration %d has both SQL files and Go code", m.Version)
+		}
+		m := m
+		byVersion[m.Version] = &m
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" && m.upFunc == nil {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for %s", dialect)
+	}
+	return migrations, nil
+}
+
+// Migrate applies every migration that hasn't been applied yet. Migrations
+// that convert data fail if they need something from opts that is missing.
+func (c Client) Migrate(opts MigrateOptions) error {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return err
+	}
+	if err := c.ensureMigrationsTable(migrations); err != nil {
+		return err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := c.inTx(func(tx *sql.Tx) error {
+			if m.Up != "" {
+				if _, err := tx.Exec(m.Up); err != nil {
+					return err
+				}
+			}
+			if m.upFunc != nil {
+				if err := m.upFunc(tx, c, opts); err != nil {
+					return err
+				}
+			}
+			return c.recordMigration(tx, m)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return c.ensureSearchIndex()
+}
+
+// Rollback reverts the most recently applied migration and returns it.
+func (c Client) Rollback() (Migration, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return Migration{}, err
+	}
+	if err := c.ensureMigrationsTable(migrations); err != nil {
+		return Migration{}, err
+	}
+
+	var version int
+	err = c.queryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return Migration{}, err
+	}
+	if version == 0 {
+		return Migration{}, ErrNoMigrationToRollBack
+	}
+	if version > len(migrations) {
+		return Migration{}, fmt.Errorf("migration %d was applied by a newer version", version)
+	}
+	m := migrations[version-1]
+	if m.Down == "" && m.upFunc == nil {
+		return Migration{}, fmt.Errorf("migration %d_%s can't be rolled back", m.Version, m.Name)
+	}
+
+	err = c.inTx(func(tx *sql.Tx) error {
+		if m.Down != "" {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(c.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+		return err
+	})
+	if err != nil {
+		return Migration{}, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	return m, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureMigrationsTable(migrations); err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (c Client) appliedMigrations() (map[int]time.Time, error) {
+	rows, err := c.query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (c Client) recordMigration(tx *sql.Tx, m Migration) error {
+	_, err := tx.Exec(c.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)"), m.Version, m.Name)
+	return err
+}
+
+// ensureMigrationsTable creates schema_migrations. An SQLite database that
+// already has tables but no schema_migrations was created by the
+// autoMigrate of older versions; it is brought up to migration 1 and
+// recorded as such.
+func (c Client) ensureMigrationsTable(migrations []Migration) error {
+	exists, err := c.tableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+	legacy := false
+	if c.dialect == DialectSQLite {
+		legacy, err = c.tableExists("videos")
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(schemaMigrationsTable[c.dialect])
+		if err != nil || !legacy {
+			return err
+		}
+
+		if err := adoptLegacySchema(tx); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[0].Up); err != nil {
+			return err
+		}
+		return c.recordMigration(tx, migrations[0])
+	})
+}
+
+func (c Client) tableExists(name string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)"
+	if c.dialect == DialectPostgres {
+		query = "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?)"
+	}
+	var exists bool
+	err := c.queryRow(query, name).Scan(&exists)
+	return exists, err
+}
+
+func (c Client) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// legacyVideoColumns are the videos columns the autoMigrate of older
+// versions added to existing tables one release at a time.
+var legacyVideoColumns = []struct{ name, definition string }{
+	{"manifest_url", "TEXT"},
+	{"status", "TEXT NOT NULL DEFAULT 'draft'"},
+	{"failure_reason", "TEXT"},
+	{"uploading_at", "TIMESTAMP"},
+	{"processing_at", "TIMESTAMP"},
+	{"ready_at", "TIMESTAMP"},
+	{"failed_at", "TIMESTAMP"},
+	{"thumbnail_sprite_url", "TEXT"},
+	{"thumbnails", "TEXT"},
+	{"deleted_at", "TIMESTAMP"},
+	{"thumbnail_object", "TEXT"},
+	{"video_object", "TEXT"},
+	{"manifest_object", "TEXT"},
+	{"thumbnail_sprite_object", "TEXT"},
+	{"thumbnail_objects", "TEXT"},
+}
+
+// adoptLegacySchema adds whatever columns the release that created a
+// database didn't have yet, so that migration 1 describes it.
+func adoptLegacySchema(tx *sql.Tx) error {
+	statusAdded := false
+	for _, col := range legacyVideoColumns {
+		added, err := ensureColumn(tx, "videos", col.name, col.definition)
+		if err != nil {
+			return err
+		}
+		statusAdded = statusAdded || (added && col.name == "status")
+	}
+	if statusAdded {
+		// videos uploaded before statuses existed are already playable
+		_, err := tx.Exec("UPDATE videos SET status = 'ready', ready_at = updated_at WHERE video_url IS NOT NULL OR video_object IS NOT NULL")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds a column to a table. It reports whether the column had
+// to be added.
+func ensureColumn(tx *sql.Tx, table, column, definition string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// legacyVideoURLs are the fully-formed URLs older versions stored in the
+// videos table instead of object refs.
+type legacyVideoURLs struct {
+	// VideoID is kept as text: rows this old don't always hold a UUID
+	VideoID            string
+	ThumbnailURL       *string
+	VideoURL           *string
+	ManifestURL        *string
+	ThumbnailSpriteURL *string
+	Thumbnails         map[int]string
+}
+
+// convertLegacyVideoURLs is migration 9. It replaces the URLs older versions
+// stored with object refs, using opts.LegacyObjectRef to tell where they
+// point. It fails rather than leave a URL behind: the garbage collector only
+// sees object refs, so it would delete what an unconverted row points at.
+func convertLegacyVideoURLs(tx *sql.Tx, c Client, opts MigrateOptions) error {
+	if c.dialect != DialectSQLite {
+		// Postgres support came after URLs stopped being stored
+		return nil
+	}
+	legacy, err := getLegacyVideoURLs(tx)
+	if err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+	if opts.LegacyObjectRef == nil {
+		return fmt.Errorf("%d videos still store URLs; start the server, which knows the stores they point at, to convert them", len(legacy))
+	}
+
+	for _, urls := range legacy {
+		var video Video
+		targets := []struct {
+			url *string
+			ref **ObjectRef
+		}{
+			{urls.ThumbnailURL, &video.ThumbnailObject},
+			{urls.VideoURL, &video.VideoObject},
+			{urls.ManifestURL, &video.ManifestObject},
+			{urls.ThumbnailSpriteURL, &video.ThumbnailSpriteObject},
+		}
+		for _, t := range targets {
+			if t.url == nil {
+				continue
+			}
+			ref, ok := opts.LegacyObjectRef(*t.url)
+			if !ok {
+				return fmt.Errorf("video %s: URL %q points at none of the configured stores", urls.VideoID, *t.url)
+			}
+			*t.ref = ref
+		}
+		for width, u := range urls.Thumbnails {
+			ref, ok := opts.LegacyObjectRef(u)
+			if !ok {
+				return fmt.Errorf("video %s: thumbnail URL %q points at none of the configured stores", urls.VideoID, u)
+			}
+			if video.ThumbnailObjects == nil {
+				video.ThumbnailObjects = ThumbnailRefs{}
+			}
+			video.ThumbnailObjects[width] = *ref
+		}
+
+		if err := replaceLegacyVideoURLs(tx, urls.VideoID, video); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getLegacyVideoURLs(tx *sql.Tx) ([]legacyVideoURLs, error) {
+	query := `
+	SELECT
+		id,
+		thumbnail_url,
+		video_url,
+		manifest_url,
+		thumbnail_sprite_url,
+		thumbnails
+	FROM videos
+	WHERE thumbnail_url IS NOT NULL
+		OR video_url IS NOT NULL
+		OR manifest_url IS NOT NULL
+		OR thumbnail_sprite_url IS NOT NULL
+		OR thumbnails IS NOT NULL
+	`
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := []legacyVideoURLs{}
+	for rows.Next() {
+		var urls legacyVideoURLs
+		var thumbnails *string
+		if err := rows.Scan(
+			&urls.VideoID,
+			&urls.ThumbnailURL,
+			&urls.VideoURL,
+			&urls.ManifestURL,
+			&urls.ThumbnailSpriteURL,
+			&thumbnails,
+		); err != nil {
+			return nil, err
+		}
+		if thumbnails != nil {
+			if err := json.Unmarshal([]byte(*thumbnails), &urls.Thumbnails); err != nil {
+				return nil, fmt.Errorf("video %s: bad thumbnails: %w", urls.VideoID, err)
+			}
+		}
+		all = append(all, urls)
+	}
+
+	return all, rows.Err()
+}
+
+// replaceLegacyVideoURLs stores the object refs of video in the row id and clears its URL
+// columns. It leaves updated_at alone: the video looks the same to its owner.
+func replaceLegacyVideoURLs(tx *sql.Tx, id string, video Video) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_object = ?,
+		video_object = ?,
+		manifest_object = ?,
+		thumbnail_sprite_object = ?,
+		thumbnail_objects = ?,
+		thumbnail_url = NULL,
+		video_url = NULL,
+		manifest_url = NULL,
+		thumbnail_sprite_url = NULL,
+		thumbnails = NULL,
+		version = version + 1
+	WHERE id = ?
+	`
+	_, err := tx.Exec(
+		query,
+		video.ThumbnailObject,
+		video.VideoObject,
+		video.ManifestObject,
+		video.ThumbnailSpriteObject,
+		video.ThumbnailObjects,
+		id,
+	)
+	return err
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func columnType(t *testing.T, c Client, table, column string) string {
+	t.Helper()
+	var colType string
+	err := c.db.QueryRow("SELECT type FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&colType)
+	if err != nil {
+		t.Fatalf("column %s.%s: %v", table, column, err)
+	}
+	return colType
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migrations, err := loadMigrations(c.dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Migrate(MigrateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		// a second run has nothing to do
+		if err := c.Migrate(MigrateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if c.dialect == DialectSQLite {
+			if got := columnType(t, c, "videos", "video_url"); got != "TEXT" {
+				t.Errorf("videos.video_url is %q, want TEXT", got)
+			}
+		}
+
+		for range migrations {
+			if _, err := c.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := c.Rollback(); err != ErrNoMigrationToRollBack {
+			t.Fatalf("Rollback() with nothing applied = %v, want ErrNoMigrationToRollBack", err)
+		}
+		for _, table := range []string{"users", "refresh_tokens", "videos", "upload_sessions", "jobs", "media_info", "sessions", "api_keys", "signing_keys", "search_index_state"} {
+			exists, err := c.tableExists(table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists {
+				t.Errorf("table %s left after rolling everything back", table)
+			}
+		}
+
+		if err := c.Migrate(MigrateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		statuses, err := c.MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != len(migrations) {
+			t.Errorf("MigrationStatus listed %d migrations, want %d", len(statuses), len(migrations))
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil {
+				t.Errorf("migration %d_%s not applied", s.Version, s.Name)
+			}
+		}
+	})
+}
+
+func TestDialectsShareVersions(t *testing.T) {
+	sqlite, err := loadMigrations(DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := loadMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d SQLite migrations but %d Postgres ones", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d is %q for SQLite but %q for Postgres", i+1, sqlite[i].Name, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	c := openSQLiteTestClient(t)
+	// a videos table from before statuses and object refs
+	_, err := c.db.Exec(`
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		password TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL
+	);
+	CREATE TABLE videos (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		video_url TEXT TEXT,
+		user_id INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	INSERT INTO videos (id, title, video_url, user_id) VALUES ('v1', 'uploaded', 'https://example.com/v1.mp4', 'u1');
+	INSERT INTO videos (id, title, user_id) VALUES ('v2', 'draft', 'u1');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v1's URL has to be converted, which the server knows how to do
+	if err := c.Migrate(MigrateOptions{}); err == nil {
+		t.Fatal("Migrate without a way to convert URLs succeeded")
+	}
+	if err := c.Migrate(MigrateOptions{LegacyObjectRef: exampleObjectRef}); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := map[string]string{}
+	rows, err := c.db.Query("SELECT id, status FROM videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			t.Fatal(err)
+		}
+		statuses[id] = status
+	}
+	if statuses["v1"] != "ready" || statuses["v2"] != "draft" {
+		t.Errorf("statuses = %v, want v1 ready and v2 draft", statuses)
+	}
+
+	var userID sql.NullString
+	if err := c.db.QueryRow("SELECT user_id FROM videos WHERE id = 'v1'").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if userID.String != "u1" {
+		t.Errorf("user_id = %q, want u1", userID.String)
+	}
+	if got := columnType(t, c, "videos", "user_id"); got != "TEXT" {
+		t.Errorf("videos.user_id is %q, want TEXT", got)
+	}
+
+	var ref ObjectRef
+	var videoURL sql.NullString
+	if err := c.db.QueryRow("SELECT video_object, video_url FROM videos WHERE id = 'v1'").Scan(&ref, &videoURL); err != nil {
+		t.Fatal(err)
+	}
+	if ref.Key != "v1.mp4" || videoURL.Valid {
+		t.Errorf("v1 has video_object %+v and video_url %v, want key v1.mp4 and no URL", ref, videoURL)
+	}
+}
+
+// exampleObjectRef maps URLs on example.com to objects of a local store.
+func exampleObjectRef(url string) (*ObjectRef, bool) {
+	key, ok := strings.CutPrefix(url, "https://example.com/")
+	if !ok {
+		return nil, false
+	}
+	return &ObjectRef{Backend: "local", Key: key}, true
+}
+
+func TestMigrateRefusesUnmappedLegacyURLs(t *testing.T) {
+	c := openSQLiteTestClient(t)
+	migratedTestClient(t, c)
+	_, video := createTestVideo(t, c)
+
+	// undo the conversion, then store a URL the way older versions did
+	for {
+		m, err := c.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Name == "legacy_url_refs" {
+			break
+		}
+	}
+	if _, err := c.db.Exec("UPDATE videos SET video_url = 'https://elsewhere.com/v.mp4' WHERE id = ?", video.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the garbage collector can't see the URL, so it must not be left behind
+	if err := c.Migrate(MigrateOptions{LegacyObjectRef: exampleObjectRef}); err == nil || !strings.Contains(err.Error(), "elsewhere.com") {
+		t.Fatalf("Migrate with an unmapped URL = %v, want an error naming it", err)
+	}
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.Name == "legacy_url_refs" && s.AppliedAt != nil {
+			t.Errorf("migration %d_%s recorded as applied after failing", s.Version, s.Name)
+		}
+	}
+	var videoURL sql.NullString
+	if err := c.db.QueryRow("SELECT video_url FROM videos WHERE id = ?", video.ID).Scan(&videoURL); err != nil {
+		t.Fatal(err)
+	}
+	if videoURL.String != "https://elsewhere.com/v.mp4" {
+		t.Errorf("video_url = %v after the failed migration, want it kept", videoURL)
+	}
+}
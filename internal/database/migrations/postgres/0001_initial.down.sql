@@ -0,0 +1,6 @@
+DROP TABLE media_info;
+DROP TABLE jobs;
+DROP TABLE upload_sessions;
+DROP TABLE videos;
+DROP TABLE refresh_tokens;
+DROP TABLE users;
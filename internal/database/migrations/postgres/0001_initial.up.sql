@@ -0,0 +1,89 @@
+CREATE TABLE users (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE videos (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_object JSONB,
+	video_object JSONB,
+	manifest_object JSONB,
+	thumbnail_sprite_object JSONB,
+	thumbnail_objects JSONB,
+	status TEXT NOT NULL DEFAULT 'draft',
+	failure_reason TEXT,
+	uploading_at TIMESTAMPTZ,
+	processing_at TIMESTAMPTZ,
+	ready_at TIMESTAMPTZ,
+	failed_at TIMESTAMPTZ,
+	deleted_at TIMESTAMPTZ,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX videos_user_id ON videos(user_id);
+CREATE INDEX videos_status ON videos(status, updated_at);
+CREATE INDEX videos_deleted_at ON videos(deleted_at);
+
+CREATE TABLE upload_sessions (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	file_path TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	completed_at TIMESTAMPTZ
+);
+
+-- jobs.video_id has no foreign key: delete_objects jobs run after their
+-- video has been purged.
+CREATE TABLE jobs (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	type TEXT NOT NULL,
+	video_id UUID NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT,
+	completed_at TIMESTAMPTZ
+);
+CREATE INDEX jobs_status_run_at ON jobs(status, run_at);
+
+CREATE TABLE media_info (
+	video_id UUID PRIMARY KEY REFERENCES videos(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	container TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	duration DOUBLE PRECISION NOT NULL,
+	bitrate BIGINT NOT NULL,
+	video_codec TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	audio_codec TEXT,
+	audio_channels INTEGER,
+	audio_sample_rate INTEGER
+);
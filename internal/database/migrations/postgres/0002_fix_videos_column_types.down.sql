@@ -0,0 +1,3 @@
+-- Only the SQLite schema needed fixing; kept so both engines share version
+-- numbers.
+SELECT 1;
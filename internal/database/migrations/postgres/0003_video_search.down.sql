@@ -0,0 +1,2 @@
+DROP INDEX videos_search;
+ALTER TABLE videos DROP COLUMN search;
@@ -0,0 +1,7 @@
+-- Titles weigh more than descriptions. The simple configuration doesn't
+-- stem, which keeps prefix matching predictable across languages.
+ALTER TABLE videos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', title), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+CREATE INDEX videos_search ON videos USING GIN (search);
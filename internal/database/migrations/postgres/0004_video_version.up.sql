@@ -0,0 +1,3 @@
+-- version counts the changes to a video, so writers can tell whether it
+-- changed since they read it.
+ALTER TABLE videos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
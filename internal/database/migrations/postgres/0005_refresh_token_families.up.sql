@@ -0,0 +1,8 @@
+-- A refresh token is replaced by a new one each time it is used. All tokens
+-- descending from one login share a family, so the whole chain can be
+-- revoked when a replaced token shows up again. Existing tokens each start
+-- a family of their own, named after the token.
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+UPDATE refresh_tokens SET family_id = token;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id DROP DEFAULT;
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens(family_id);
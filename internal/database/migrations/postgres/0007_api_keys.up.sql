@@ -0,0 +1,15 @@
+-- Keys are only stored as SHA-256 hashes; prefix is the start of the key,
+-- so users can tell their keys apart.
+CREATE TABLE api_keys (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	prefix TEXT NOT NULL,
+	scopes JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+CREATE INDEX api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,10 @@
+-- Keys access tokens are signed with, when they are signed with RS256 or
+-- EdDSA. The key without retired_at signs new tokens; retired keys only
+-- validate the tokens they signed until those have expired.
+CREATE TABLE signing_keys (
+	id TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	private_key TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	retired_at TIMESTAMPTZ
+);
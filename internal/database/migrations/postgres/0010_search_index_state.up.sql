@@ -0,0 +1,2 @@
+-- The search column of videos is generated, so it can't fall behind.
+SELECT 1;
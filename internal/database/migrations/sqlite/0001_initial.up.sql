@@ -0,0 +1,100 @@
+-- The schema as autoMigrate left it, so databases created before versioned
+-- migrations can be adopted as version 1.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	manifest_url TEXT,
+	thumbnail_sprite_url TEXT,
+	thumbnails TEXT,
+	thumbnail_object TEXT,
+	video_object TEXT,
+	manifest_object TEXT,
+	thumbnail_sprite_object TEXT,
+	thumbnail_objects TEXT,
+	status TEXT NOT NULL DEFAULT 'draft',
+	failure_reason TEXT,
+	uploading_at TIMESTAMP,
+	processing_at TIMESTAMP,
+	ready_at TIMESTAMP,
+	failed_at TIMESTAMP,
+	deleted_at TIMESTAMP,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS videos_status ON videos(status, updated_at);
+CREATE INDEX IF NOT EXISTS videos_deleted_at ON videos(deleted_at);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	upload_length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	file_path TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	type TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS jobs_status_run_at ON jobs(status, run_at);
+
+CREATE TABLE IF NOT EXISTS media_info (
+	video_id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	container TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	duration REAL NOT NULL,
+	bitrate INTEGER NOT NULL,
+	video_codec TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	frame_rate REAL NOT NULL,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	audio_codec TEXT,
+	audio_channels INTEGER,
+	audio_sample_rate INTEGER,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
@@ -0,0 +1,36 @@
+-- videos.video_url was declared "TEXT TEXT" and videos.user_id INTEGER,
+-- although user IDs are UUID strings. SQLite can't change a column's type,
+-- so the table is rebuilt.
+
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	manifest_url TEXT,
+	thumbnail_sprite_url TEXT,
+	thumbnails TEXT,
+	thumbnail_object TEXT,
+	video_object TEXT,
+	manifest_object TEXT,
+	thumbnail_sprite_object TEXT,
+	thumbnail_objects TEXT,
+	status TEXT NOT NULL DEFAULT 'draft',
+	failure_reason TEXT,
+	uploading_at TIMESTAMP,
+	processing_at TIMESTAMP,
+	ready_at TIMESTAMP,
+	failed_at TIMESTAMP,
+	deleted_at TIMESTAMP,
+	user_id TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, manifest_url, thumbnail_sprite_url, thumbnails, thumbnail_object, video_object, manifest_object, thumbnail_sprite_object, thumbnail_objects, status, failure_reason, uploading_at, processing_at, ready_at, failed_at, deleted_at, user_id)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, manifest_url, thumbnail_sprite_url, thumbnails, thumbnail_object, video_object, manifest_object, thumbnail_sprite_object, thumbnail_objects, status, failure_reason, uploading_at, processing_at, ready_at, failed_at, deleted_at, user_id FROM videos;
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+CREATE INDEX videos_status ON videos(status, updated_at);
+CREATE INDEX videos_deleted_at ON videos(deleted_at);
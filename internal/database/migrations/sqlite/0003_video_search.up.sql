@@ -0,0 +1,5 @@
+-- The SQLite search index, videos_fts, needs FTS5, which go-sqlite3 only
+-- compiles in with the sqlite_fts5 build tag. It is derived data, so the
+-- client creates and fills it at startup when it can (see
+-- ensureSearchIndex) and searches with LIKE otherwise.
+SELECT 1;
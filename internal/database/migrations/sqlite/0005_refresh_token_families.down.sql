@@ -0,0 +1,2 @@
+DROP INDEX refresh_tokens_family_id;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,22 @@
+-- A session is a refresh token family: one login and every token rotated
+-- from it. It is active while one of its tokens is neither revoked nor
+-- expired.
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX sessions_user_id ON sessions(user_id);
+
+-- Families from before 0005 are named after their token, which must not
+-- show up as a session ID.
+UPDATE refresh_tokens SET family_id = lower(hex(randomblob(16))) WHERE family_id = token;
+
+INSERT INTO sessions (id, user_id, created_at, last_used_at)
+SELECT family_id, user_id, MIN(created_at), MAX(created_at)
+FROM refresh_tokens
+GROUP BY family_id, user_id;
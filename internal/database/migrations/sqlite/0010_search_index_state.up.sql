@@ -0,0 +1,8 @@
+-- Whether videos_fts has fallen behind videos. Builds without FTS5 can't
+-- write to it, so they mark it stale and the next build with FTS5 refills it
+-- (see ensureSearchIndex).
+CREATE TABLE search_index_state (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	stale BOOLEAN NOT NULL
+);
+INSERT INTO search_index_state (id, stale) VALUES (1, FALSE);
@@ -0,0 +1,4 @@
+-- Until when the instance running a job holds it. Workers extend the lease
+-- while they run the job; a running job whose lease has expired belongs to
+-- an instance that died and goes back to the queue.
+ALTER TABLE jobs ADD COLUMN locked_until TIMESTAMP;
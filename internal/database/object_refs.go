@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ObjectRef locates a stored object independently of how it is served. It
+// is stored as a JSON object; URLs are worked out when responding.
+type ObjectRef struct {
+	Backend string `json:"backend"`
+	Bucket  string `json:"bucket,omitempty"`
+	Key     string `json:"key"`
+}
+
+func (r *ObjectRef) Scan(src any) error {
+	return scanJSON(src, r)
+}
+
+func (r ObjectRef) Value() (driver.Value, error) {
+	return valueJSON(r)
+}
+
+// ThumbnailRefs maps the width in pixels of each thumbnail variant to where
+// it is stored.
+type ThumbnailRefs map[int]ObjectRef
+
+func (t *ThumbnailRefs) Scan(src any) error {
+	if src == nil {
+		*t = nil
+		return nil
+	}
+	return scanJSON(src, t)
+}
+
+func (t ThumbnailRefs) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return valueJSON(t)
+}
+
+func scanJSON(src, dest any) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), dest)
+	case []byte:
+		return json.Unmarshal(src, dest)
+	}
+	return fmt.Errorf("cannot scan %T into %T", src, dest)
+}
+
+func valueJSON(v any) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// ObjectKeyReferenced reports whether any video, trashed ones included,
+// references the object with the given key, either directly or as part of
+// the HLS package next to its manifest. Keys are random, so their store isn't
+// compared.
+func (c Client) ObjectKeyReferenced(key string) (bool, error) {
+	refKey := func(column string) string {
+		if c.dialect == DialectPostgres {
+			return column + "->>'key'"
+		}
+		return "json_extract(" + column + ", '$.key')"
+	}
+	eachThumbnail := "SELECT 1 FROM json_each(thumbnail_objects) WHERE json_extract(value, '$.key') = ?"
+	if c.dialect == DialectPostgres {
+		eachThumbnail = "SELECT 1 FROM jsonb_each(thumbnail_objects) WHERE value->>'key' = ?"
+	}
+
+	conditions := []string{
+		refKey("video_object") + " = ?",
+		refKey("thumbnail_object") + " = ?",
+		refKey("thumbnail_sprite_object") + " = ?",
+		"EXISTS (" + eachThumbnail + ")",
+	}
+	args := []any{key, key, key, key}
+	// an HLS package is everything in the directory of its manifest
+	manifest := refKey("manifest_object")
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		prefix := likeEscape(dir) + "/"
+		conditions = append(conditions, "("+manifest+` LIKE ? ESCAPE '\' AND `+manifest+` NOT LIKE ? ESCAPE '\')`)
+		args = append(args, prefix+"%", prefix+"%/%")
+	}
+
+	query := `SELECT EXISTS (SELECT 1 FROM videos WHERE ` + strings.Join(conditions, " OR ") + `)`
+	var referenced bool
+	err := c.queryRow(query, args...).Scan(&referenced)
+	return referenced, err
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern, for ESCAPE '\'.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
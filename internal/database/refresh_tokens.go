@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,24 +19,35 @@ type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID groups a token with the ones it was rotated from. A token
+	// issued at login starts a new family.
+	FamilyID string `json:"family_id"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	err := c.inTx(func(tx *sql.Tx) error {
+		return c.insertRefreshToken(tx, params)
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(params.Token)
+}
+
+func (c Client) insertRefreshToken(tx *sql.Tx, params CreateRefreshTokenParams) error {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
-	if err != nil {
-		return RefreshToken{}, err
-	}
-
-	return c.GetRefreshToken(params.Token)
+	_, err := tx.Exec(c.rebind(query), params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID)
+	return err
 }
 
 func (c Client) RevokeRefreshToken(token string) error {
@@ -44,23 +56,63 @@ func (c Client) RevokeRefreshToken(token string) error {
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
+	return err
+}
+
+// RotateRefreshToken revokes the refresh token old and creates next in its
+// family in its place. It returns ErrConflict, changing nothing, if old was
+// already revoked, e.g. by a concurrent rotation.
+func (c Client) RotateRefreshToken(old string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	err := c.inTx(func(tx *sql.Tx) error {
+		var familyID string
+		err := tx.QueryRow(c.rebind(`
+			UPDATE refresh_tokens
+			SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE token = ? AND revoked_at IS NULL
+			RETURNING family_id
+		`), old).Scan(&familyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		next.FamilyID = familyID
+		return c.insertRefreshToken(tx, next)
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return c.GetRefreshToken(next.Token)
+}
+
+// RevokeRefreshTokenFamily revokes every token of a family that isn't
+// revoked yet.
+func (c Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, familyID)
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.queryRow(query, token).
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.FamilyID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return RefreshToken{}, nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrNotFound
 		}
 		return RefreshToken{}, err
 	}
@@ -78,6 +130,6 @@ func (c Client) DeleteRefreshToken(token string) error {
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
 	return err
 }
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login on some device, kept alive by refreshing its refresh
+// token. Its ID is the FamilyID of those tokens.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+}
+
+type CreateSessionParams struct {
+	UserID    uuid.UUID
+	UserAgent string
+	IP        string
+	// RefreshToken starts the session's family of refresh tokens. A session
+	// without one would never be active.
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// CreateSession creates a session along with its first refresh token.
+func (c Client) CreateSession(params CreateSessionParams) (Session, error) {
+	id := uuid.NewString()
+	query := `
+	INSERT INTO sessions (id, user_id, created_at, last_used_at, user_agent, ip)
+	VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	err := c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(c.rebind(query), id, params.UserID.String(), params.UserAgent, params.IP)
+		if err != nil {
+			return err
+		}
+		return c.insertRefreshToken(tx, CreateRefreshTokenParams{
+			Token:     params.RefreshToken,
+			UserID:    params.UserID,
+			ExpiresAt: params.RefreshTokenExpiresAt,
+			FamilyID:  id,
+		})
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	var session Session
+	var userID string
+	err = c.queryRow(`
+	SELECT id, user_id, created_at, last_used_at, user_agent, ip
+	FROM sessions
+	WHERE id = ?
+	`, id).Scan(&session.ID, &userID, &session.CreatedAt, &session.LastUsedAt, &session.UserAgent, &session.IP)
+	if err != nil {
+		return Session{}, err
+	}
+	session.UserID, err = uuid.Parse(userID)
+	return session, err
+}
+
+// TouchSession records that a session was used just now, from userAgent at
+// ip.
+func (c Client) TouchSession(id, userAgent, ip string) error {
+	query := `
+	UPDATE sessions
+	SET last_used_at = CURRENT_TIMESTAMP, user_agent = ?, ip = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, userAgent, ip, id)
+	return err
+}
+
+// sessionActive is the condition on a session s of having a refresh token
+// that can still be used, given the time now as an argument.
+const sessionActive = `EXISTS (
+		SELECT 1 FROM refresh_tokens rt
+		WHERE rt.family_id = s.id AND rt.revoked_at IS NULL AND rt.expires_at > ?
+	)`
+
+// SessionActive reports whether id is a session of the user that hasn't
+// ended.
+func (c Client) SessionActive(userID uuid.UUID, id string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM sessions s
+		WHERE s.id = ? AND s.user_id = ? AND ` + sessionActive + `
+	)
+	`
+	var active bool
+	err := c.queryRow(query, id, userID.String(), time.Now().UTC()).Scan(&active)
+	return active, err
+}
+
+// ListSessions returns the active sessions of a user, most recently used
+// first.
+func (c Client) ListSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+	SELECT s.id, s.user_id, s.created_at, s.last_used_at, s.user_agent, s.ip
+	FROM sessions s
+	WHERE s.user_id = ? AND ` + sessionActive + `
+	ORDER BY s.last_used_at DESC, s.id
+	`
+	rows, err := c.query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var id string
+		err := rows.Scan(&session.ID, &id, &session.CreatedAt, &session.LastUsedAt, &session.UserAgent, &session.IP)
+		if err != nil {
+			return nil, err
+		}
+		session.UserID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends a session of a user by revoking its refresh tokens. It
+// returns ErrNotFound if the user has no such active session.
+func (c Client) RevokeSession(userID uuid.UUID, id string) error {
+	query := `
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE family_id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`
+	res, err := c.exec(query, id, userID.String(), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of a user.
+func (c Client) RevokeAllSessions(userID uuid.UUID) error {
+	query := `
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, userID.String())
+	return err
+}
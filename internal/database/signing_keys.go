@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey is a key access tokens are signed with. PrivateKey is stored
+// as given; the caller encrypts it.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+type CreateSigningKeyParams struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+}
+
+const signingKeyColumns = `
+		id,
+		algorithm,
+		private_key,
+		created_at,
+		retired_at`
+
+func scanSigningKey(row rowScanner) (SigningKey, error) {
+	var key SigningKey
+	err := row.Scan(
+		&key.ID,
+		&key.Algorithm,
+		&key.PrivateKey,
+		&key.CreatedAt,
+		&key.RetiredAt,
+	)
+	return key, err
+}
+
+// CreateSigningKey stores a key to sign new tokens with and retires the keys
+// that did so far.
+func (c Client) CreateSigningKey(params CreateSigningKeyParams) (SigningKey, error) {
+	now := time.Now().UTC()
+	err := c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(c.rebind(`
+			UPDATE signing_keys
+			SET retired_at = ?
+			WHERE retired_at IS NULL
+		`), now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(c.rebind(`
+			INSERT INTO signing_keys (id, algorithm, private_key, created_at)
+			VALUES (?, ?, ?, ?)
+		`), params.ID, params.Algorithm, params.PrivateKey, now)
+		return err
+	})
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return scanSigningKey(c.queryRow(`SELECT`+signingKeyColumns+` FROM signing_keys WHERE id = ?`, params.ID))
+}
+
+// ListSigningKeys returns the keys that aren't retired or were retired after
+// since, newest first.
+func (c Client) ListSigningKeys(since time.Time) ([]SigningKey, error) {
+	query := `SELECT` + signingKeyColumns + `
+	FROM signing_keys
+	WHERE retired_at IS NULL OR retired_at > ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.query(query, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SetSigningKeyPrivateKey replaces the stored private key of a key, e.g.
+// with an encrypted copy.
+func (c Client) SetSigningKeyPrivateKey(id, privateKey string) error {
+	res, err := c.exec(`UPDATE signing_keys SET private_key = ? WHERE id = ?`, privateKey, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteSigningKeysRetiredBefore removes keys that were retired before
+// cutoff, so their private keys aren't kept around for longer than needed.
+func (c Client) DeleteSigningKeysRetiredBefore(cutoff time.Time) error {
+	_, err := c.exec(`DELETE FROM signing_keys WHERE retired_at < ?`, cutoff.UTC())
+	return err
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UploadSession struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Offset      int64      `json:"offset"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateUploadSessionParams
+}
+
+type CreateUploadSessionParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Length    int64     `json:"length"`
+	FilePath  string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateUploadSession(params CreateUploadSessionParams) (UploadSession, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO upload_sessions (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		file_path,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.exec(query, id, params.VideoID, params.UserID, params.Length, params.FilePath, params.ExpiresAt)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return c.GetUploadSession(id)
+}
+
+const uploadSessionColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		file_path,
+		expires_at,
+		completed_at`
+
+func scanUploadSession(row rowScanner) (UploadSession, error) {
+	var session UploadSession
+	err := row.Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.VideoID,
+		&session.UserID,
+		&session.Length,
+		&session.Offset,
+		&session.FilePath,
+		&session.ExpiresAt,
+		&session.CompletedAt,
+	)
+	return session, err
+}
+
+func (c Client) GetUploadSession(id uuid.UUID) (UploadSession, error) {
+	session, err := scanUploadSession(c.queryRow(`SELECT`+uploadSessionColumns+` FROM upload_sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UploadSession{}, ErrNotFound
+	}
+	return session, err
+}
+
+// ListExpiredUploadSessions returns the sessions, completed or not, that
+// expired before now.
+func (c Client) ListExpiredUploadSessions(now time.Time) ([]UploadSession, error) {
+	query := `SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE expires_at < ?
+	ORDER BY expires_at
+	`
+	rows, err := c.query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UploadSession{}
+	for rows.Next() {
+		session, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// AdvanceUploadSession moves the offset of an upload from `from` to `to`. It
+// reports false if the stored offset was no longer `from`.
+func (c Client) AdvanceUploadSession(id uuid.UUID, from, to int64) (bool, error) {
+	query := `
+	UPDATE upload_sessions
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND upload_offset = ?
+	`
+	res, err := c.exec(query, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c Client) CompleteUploadSession(id uuid.UUID) error {
+	query := `
+	UPDATE upload_sessions
+	SET
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+func (c Client) DeleteUploadSession(id uuid.UUID) error {
+	query := `
+	DELETE FROM upload_sessions
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
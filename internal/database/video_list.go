@@ -0,0 +1,226 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoSort string
+
+const (
+	VideoSortCreated  VideoSort = "created"
+	VideoSortUpdated  VideoSort = "updated"
+	VideoSortTitle    VideoSort = "title"
+	VideoSortDuration VideoSort = "duration"
+)
+
+// sort key of each VideoSort, given the aliases of videos and media_info
+var videoSortKeys = map[VideoSort]func(v, mi string) string{
+	VideoSortCreated:  func(v, mi string) string { return v + ".created_at" },
+	VideoSortUpdated:  func(v, mi string) string { return v + ".updated_at" },
+	VideoSortTitle:    func(v, mi string) string { return "LOWER(" + v + ".title)" },
+	VideoSortDuration: func(v, mi string) string { return "COALESCE(" + mi + ".duration, 0)" },
+}
+
+func (s VideoSort) Valid() bool {
+	_, ok := videoSortKeys[s]
+	return ok
+}
+
+// Orientation is worked out from the stored media info, so videos that
+// haven't been processed yet have none.
+type Orientation string
+
+const (
+	OrientationLandscape Orientation = "landscape"
+	OrientationPortrait  Orientation = "portrait"
+	OrientationSquare    Orientation = "square"
+)
+
+var orientationOperators = map[Orientation]string{
+	OrientationLandscape: ">",
+	OrientationPortrait:  "<",
+	OrientationSquare:    "=",
+}
+
+func (o Orientation) Valid() bool {
+	_, ok := orientationOperators[o]
+	return ok
+}
+
+type ListVideosParams struct {
+	UserID     uuid.UUID
+	Sort       VideoSort
+	Descending bool
+	// After is where the previous page ended, or nil for the first page.
+	After *VideoCursor
+	Limit int
+
+	// Filters; nil or empty ones match every video.
+	HasVideo      *bool
+	HasThumbnail  *bool
+	Statuses      []VideoStatus
+	Orientation   Orientation
+	CreatedAfter  *time.Time // inclusive
+	CreatedBefore *time.Time // exclusive
+}
+
+// VideoCursor is where a page of ListVideos ended: the sort key and ID of
+// its last video, so the next page doesn't depend on that video still being
+// there or unchanged. Only the key of the sort in use is set.
+type VideoCursor struct {
+	Time     *time.Time `json:"time,omitempty"`
+	Title    *string    `json:"title,omitempty"`
+	Duration *float64   `json:"duration,omitempty"`
+	ID       uuid.UUID  `json:"id"`
+}
+
+// Valid reports whether the cursor carries the key of s.
+func (cursor VideoCursor) Valid(s VideoSort) bool {
+	switch s {
+	case VideoSortCreated, VideoSortUpdated:
+		return cursor.Time != nil
+	case VideoSortTitle:
+		return cursor.Title != nil
+	case VideoSortDuration:
+		return cursor.Duration != nil
+	}
+	return false
+}
+
+// videoColumnsOf is videoColumns qualified with a table alias.
+func videoColumnsOf(alias string) string {
+	columns := strings.Split(videoColumns, ",")
+	for i, column := range columns {
+		columns[i] = "\n\t\t" + alias + "." + strings.TrimSpace(column)
+	}
+	return strings.Join(columns, ",")
+}
+
+// ListVideos returns a page of the user's videos that aren't in the trash,
+// ordered by params.Sort with ties broken by ID. next is where the following
+// page starts, or nil if this is the last one.
+func (c Client) ListVideos(params ListVideosParams) (videos []Video, next *VideoCursor, err error) {
+	sortKey, ok := videoSortKeys[params.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+
+	conditions := []string{"v.user_id = ?", "v.deleted_at IS NULL"}
+	args := []any{params.UserID}
+	if params.HasVideo != nil {
+		conditions = append(conditions, nullCondition("v.video_object", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		conditions = append(conditions, nullCondition("v.thumbnail_object", *params.HasThumbnail))
+	}
+	if len(params.Statuses) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(params.Statuses)), ", ")
+		conditions = append(conditions, "v.status IN ("+placeholders+")")
+		for _, status := range params.Statuses {
+			args = append(args, status)
+		}
+	}
+	if params.Orientation != "" {
+		operator, ok := orientationOperators[params.Orientation]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown orientation %q", params.Orientation)
+		}
+		// media_info stores the size before rotation
+		conditions = append(conditions, fmt.Sprintf(
+			"CASE WHEN mi.rotation IN (90, 270) THEN mi.height ELSE mi.width END %s CASE WHEN mi.rotation IN (90, 270) THEN mi.width ELSE mi.height END",
+			operator,
+		))
+	}
+	if params.CreatedAfter != nil {
+		conditions = append(conditions, "v.created_at >= ?")
+		args = append(args, c.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		conditions = append(conditions, "v.created_at < ?")
+		args = append(args, c.timeArg(*params.CreatedBefore))
+	}
+
+	comparison, direction := ">", "ASC"
+	if params.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	if params.After != nil {
+		if !params.After.Valid(params.Sort) {
+			return nil, nil, fmt.Errorf("cursor has no %s key", params.Sort)
+		}
+		// comparing with the key rather than re-reading the row keeps the
+		// position when that video is renamed, updated or purged
+		var after any
+		switch params.Sort {
+		case VideoSortCreated, VideoSortUpdated:
+			after = c.timeArg(*params.After.Time)
+		case VideoSortTitle:
+			after = *params.After.Title
+		case VideoSortDuration:
+			after = *params.After.Duration
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, v.id) %s (?, ?)", sortKey("v", "mi"), comparison))
+		args = append(args, after, params.After.ID)
+	}
+
+	query := `
+	SELECT` + videoColumnsOf("v") + `,
+		` + sortKey("v", "mi") + `
+	FROM videos v
+	LEFT JOIN media_info mi ON mi.video_id = v.id
+	WHERE ` + strings.Join(conditions, "\n\t\tAND ") + `
+	ORDER BY ` + sortKey("v", "mi") + ` ` + direction + `, v.id ` + direction + `
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	videos = []Video{}
+	cursors := []VideoCursor{}
+	for rows.Next() {
+		var video Video
+		var cursor VideoCursor
+		var key any
+		switch params.Sort {
+		case VideoSortCreated, VideoSortUpdated:
+			cursor.Time = new(time.Time)
+			key = cursor.Time
+		case VideoSortTitle:
+			cursor.Title = new(string)
+			key = cursor.Title
+		case VideoSortDuration:
+			cursor.Duration = new(float64)
+			key = cursor.Duration
+		}
+		if err := rows.Scan(append(videoScanDest(&video), key)...); err != nil {
+			return nil, nil, err
+		}
+		cursor.ID = video.ID
+		videos = append(videos, video)
+		cursors = append(cursors, cursor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(videos) > params.Limit {
+		return videos[:params.Limit], &cursors[params.Limit-1], nil
+	}
+	return videos, nil, nil
+}
+
+func nullCondition(column string, notNull bool) string {
+	if notNull {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
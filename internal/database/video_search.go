@@ -0,0 +1,321 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Highlighted text in search results marks matches with these runes. They
+// can't occur in text typed by users, so callers can escape the text for
+// their output format and then swap the markers for real markup.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// snippetWords is roughly how many words of a description a snippet shows.
+const snippetWords = 16
+
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	// Query is free text; every word in it has to match the start of a
+	// word in the title or description.
+	Query  string
+	Limit  int
+	Offset int
+}
+
+type VideoSearchResult struct {
+	Video Video
+	// Rank orders results; higher is better. Values are only comparable
+	// within one search.
+	Rank float64
+	// TitleHighlight is the title and DescriptionSnippet the part of the
+	// description around the matches, both with HighlightStart and
+	// HighlightEnd around matching words.
+	TitleHighlight     string
+	DescriptionSnippet string
+}
+
+// SearchTerms splits a search query into the words it matches on.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchVideos returns a page of the user's videos, other than those in the
+// trash, matching params.Query, best first. more reports whether another
+// page follows.
+func (c Client) SearchVideos(params SearchVideosParams) (results []VideoSearchResult, more bool, err error) {
+	terms := SearchTerms(params.Query)
+	if len(terms) == 0 {
+		return []VideoSearchResult{}, false, nil
+	}
+
+	switch {
+	case c.dialect == DialectPostgres:
+		results, err = c.searchVideosPostgres(params, terms)
+	case c.fts:
+		results, err = c.searchVideosFTS(params, terms)
+	default:
+		results, err = c.searchVideosLike(params, terms)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(results) > params.Limit {
+		return results[:params.Limit], true, nil
+	}
+	return results, false, nil
+}
+
+func (c Client) scanSearchResults(query string, args ...any) ([]VideoSearchResult, error) {
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var video Video
+		err := rows.Scan(append(videoScanDest(&video), &result.Rank, &result.TitleHighlight, &result.DescriptionSnippet)...)
+		if err != nil {
+			return nil, err
+		}
+		result.Video = video
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (c Client) searchVideosPostgres(params SearchVideosParams, terms []string) ([]VideoSearchResult, error) {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	markers := fmt.Sprintf("StartSel=%s, StopSel=%s", HighlightStart, HighlightEnd)
+	titleOptions := markers + ", HighlightAll=true"
+	snippetOptions := fmt.Sprintf("%s, MaxWords=%d, MinWords=%d", markers, snippetWords, snippetWords/2)
+
+	query := `
+	SELECT` + videoColumnsOf("v") + `,
+		ts_rank(v.search, q),
+		ts_headline('simple', v.title, q, ?),
+		ts_headline('simple', COALESCE(v.description, ''), q, ?)
+	FROM videos v, to_tsquery('simple', ?) q
+	WHERE v.search @@ q AND v.user_id = ? AND v.deleted_at IS NULL
+	ORDER BY ts_rank(v.search, q) DESC, v.id
+	LIMIT ? OFFSET ?
+	`
+	return c.scanSearchResults(query, titleOptions, snippetOptions, strings.Join(prefixes, " & "), params.UserID, params.Limit+1, params.Offset)
+}
+
+func (c Client) searchVideosFTS(params SearchVideosParams, terms []string) ([]VideoSearchResult, error) {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		// terms are letters and digits only, so quoting is all it takes
+		prefixes[i] = `"` + term + `"*`
+	}
+
+	// bm25 is lower for better matches; titles weigh ten times as much
+	query := `
+	SELECT` + videoColumnsOf("v") + `,
+		-bm25(videos_fts, 10.0, 1.0),
+		highlight(videos_fts, 0, ?, ?),
+		snippet(videos_fts, 1, ?, ?, '…', ?)
+	FROM videos_fts
+	JOIN videos v ON v.id = videos_fts.video_id
+	WHERE videos_fts MATCH ? AND v.user_id = ? AND v.deleted_at IS NULL
+	ORDER BY bm25(videos_fts, 10.0, 1.0), v.id
+	LIMIT ? OFFSET ?
+	`
+	return c.scanSearchResults(
+		query,
+		HighlightStart, HighlightEnd,
+		HighlightStart, HighlightEnd, snippetWords,
+		strings.Join(prefixes, " "),
+		params.UserID,
+		params.Limit+1, params.Offset,
+	)
+}
+
+// searchVideosLike is the search of SQLite builds without FTS5. Terms match
+// anywhere in a word and videos matching in the title come first.
+func (c Client) searchVideosLike(params SearchVideosParams, terms []string) ([]VideoSearchResult, error) {
+	titleMatches := []string{}
+	conditions := []string{"v.user_id = ?", "v.deleted_at IS NULL"}
+	var rankArgs []any
+	whereArgs := []any{params.UserID}
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		titleMatches = append(titleMatches, "(LOWER(v.title) LIKE ?)")
+		rankArgs = append(rankArgs, pattern)
+		conditions = append(conditions, "(LOWER(v.title) LIKE ? OR LOWER(COALESCE(v.description, '')) LIKE ?)")
+		whereArgs = append(whereArgs, pattern, pattern)
+	}
+
+	query := `
+	SELECT` + videoColumnsOf("v") + `,
+		` + strings.Join(titleMatches, " + ") + ` AS rank,
+		'',
+		''
+	FROM videos v
+	WHERE ` + strings.Join(conditions, "\n\t\tAND ") + `
+	ORDER BY rank DESC, v.created_at DESC, v.id
+	LIMIT ? OFFSET ?
+	`
+	args := append(append(rankArgs, whereArgs...), params.Limit+1, params.Offset)
+
+	results, err := c.scanSearchResults(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		video := results[i].Video
+		results[i].TitleHighlight = highlightTerms(video.Title, terms)
+		results[i].DescriptionSnippet = snippetAround(video.Description, terms)
+	}
+	return results, nil
+}
+
+// highlightTerms marks the parts of text matching any of terms.
+func highlightTerms(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// lowercasing changed byte offsets; leave the text as it is
+		return text
+	}
+
+	type span struct{ start, end int }
+	spans := []span{}
+	for _, term := range terms {
+		for from := 0; ; {
+			i := strings.Index(lower[from:], term)
+			if i < 0 {
+				break
+			}
+			spans = append(spans, span{from + i, from + i + len(term)})
+			from += i + 1
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for i := 0; i < len(spans); i++ {
+		start, end := spans[i].start, spans[i].end
+		// merge overlapping matches
+		for i+1 < len(spans) && spans[i+1].start <= end {
+			i++
+			end = max(end, spans[i].end)
+		}
+		b.WriteString(text[pos:start])
+		b.WriteString(HighlightStart + text[start:end] + HighlightEnd)
+		pos = end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// snippetAround returns about snippetWords words of text starting a little
+// before the first word matching a term, highlighted.
+func snippetAround(text string, terms []string) string {
+	words := strings.Fields(text)
+	first := 0
+	for i, word := range words {
+		if matchesAny(strings.ToLower(word), terms) {
+			first = i
+			break
+		}
+	}
+	start := max(0, first-snippetWords/4)
+	end := min(len(words), start+snippetWords)
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return highlightTerms(snippet, terms)
+}
+
+func matchesAny(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.Contains(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureSearchIndex keeps videos_fts in step with videos across builds
+// with and without FTS5. Every write to videos updates the index in the same
+// transaction, but builds without FTS5 can't, so they mark it stale. A build
+// with FTS5 creates the index if it's missing and refills it if it's stale.
+func (c Client) ensureSearchIndex() error {
+	if c.dialect != DialectSQLite {
+		return nil
+	}
+	if !c.fts {
+		_, err := c.exec("UPDATE search_index_state SET stale = TRUE")
+		return err
+	}
+
+	exists, err := c.tableExists("videos_fts")
+	if err != nil {
+		return err
+	}
+	var stale bool
+	if err := c.queryRow("SELECT stale FROM search_index_state").Scan(&stale); err != nil {
+		return err
+	}
+	if exists && !stale {
+		return nil
+	}
+	return c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+			title,
+			description,
+			video_id UNINDEXED,
+			tokenize = 'unicode61 remove_diacritics 2'
+		);
+		DELETE FROM videos_fts;
+		INSERT INTO videos_fts (title, description, video_id)
+		SELECT title, COALESCE(description, ''), id FROM videos;
+		UPDATE search_index_state SET stale = FALSE;
+		`)
+		return err
+	})
+}
+
+// indexVideo brings the search index entry of a video up to date, in the
+// transaction that writes the video.
+func (c Client) indexVideo(tx *sql.Tx, id uuid.UUID, title, description string) error {
+	if !c.fts {
+		return nil
+	}
+	if err := c.unindexVideo(tx, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO videos_fts (title, description, video_id) VALUES (?, ?, ?)", title, description, id)
+	return err
+}
+
+func (c Client) unindexVideo(tx *sql.Tx, id uuid.UUID) error {
+	if !c.fts {
+		return nil
+	}
+	_, err := tx.Exec("DELETE FROM videos_fts WHERE video_id = ?", id)
+	return err
+}
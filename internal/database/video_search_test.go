@@ -0,0 +1,156 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchVideos(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c Client) {
+		migratedTestClient(t, c)
+		user, err := c.CreateUser(CreateUserParams{Email: "boots@example.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		create := func(title, description string) Video {
+			t.Helper()
+			video, err := c.CreateVideo(CreateVideoParams{Title: title, Description: description, UserID: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return video
+		}
+		search := func(query string, limit, offset int) ([]VideoSearchResult, bool) {
+			t.Helper()
+			results, more, err := c.SearchVideos(SearchVideosParams{UserID: user.ID, Query: query, Limit: limit, Offset: offset})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return results, more
+		}
+		titles := func(results []VideoSearchResult) []string {
+			got := []string{}
+			for _, r := range results {
+				got = append(got, r.Video.Title)
+			}
+			return got
+		}
+
+		create("Boots the bear", "A short clip about a polar bear")
+		create("Cooking pasta", "Boots makes dinner for the whole family, then watches a long movie about nothing in particular")
+		trashed := create("Boots in the trash", "")
+		create("Unrelated", "Nothing to see")
+		if _, err := c.TrashVideo(trashed.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		results, more := search("boo", 10, 0)
+		if got := titles(results); len(got) != 2 || got[0] != "Boots the bear" || more {
+			t.Fatalf("search for boo = %v (more %v), want the title match before the description match", got, more)
+		}
+		if !strings.Contains(results[0].TitleHighlight, HighlightStart+"Boots"+HighlightEnd) &&
+			!strings.Contains(results[0].TitleHighlight, HighlightStart+"Boo"+HighlightEnd) {
+			t.Errorf("title highlight %q doesn't mark Boots", results[0].TitleHighlight)
+		}
+		if !strings.Contains(results[1].DescriptionSnippet, HighlightStart) {
+			t.Errorf("description snippet %q has no highlight", results[1].DescriptionSnippet)
+		}
+
+		// every word has to match
+		if results, _ := search("boots pasta", 10, 0); len(results) != 1 || results[0].Video.Title != "Cooking pasta" {
+			t.Errorf("search for boots pasta = %v, want Cooking pasta", titles(results))
+		}
+
+		first, more := search("boots", 1, 0)
+		second, moreAfter := search("boots", 1, 1)
+		if len(first) != 1 || !more || len(second) != 1 || moreAfter || first[0].Video.ID == second[0].Video.ID {
+			t.Errorf("pages = %v (more %v), %v (more %v); want two different single results", titles(first), more, titles(second), moreAfter)
+		}
+
+		// the index follows updates and deletes
+		video := results[0].Video
+		video.Title = "Grizzly"
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		if results, _ := search("grizz", 10, 0); len(results) != 1 {
+			t.Errorf("search for the new title returned %v", titles(results))
+		}
+		if err := c.DeleteVideo(video.ID); err != nil {
+			t.Fatal(err)
+		}
+		if results, _ := search("grizz", 10, 0); len(results) != 0 {
+			t.Errorf("search after delete returned %v", titles(results))
+		}
+
+		if results, _ := search("  ... ", 10, 0); len(results) != 0 {
+			t.Errorf("search without words returned %v", titles(results))
+		}
+	})
+}
+
+func TestHighlightTerms(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Boots the bear", []string{"boo"}, "[Boo]ts the bear"},
+		{"Boots the bear", []string{"b", "bea"}, "[B]oots the [bea]r"},
+		{"banana", []string{"ana", "nan"}, "b[anana]"},
+		{"nothing", []string{"x"}, "nothing"},
+	}
+	for _, tt := range tests {
+		got := highlightTerms(tt.text, tt.terms)
+		got = strings.NewReplacer(HighlightStart, "[", HighlightEnd, "]").Replace(got)
+		if got != tt.want {
+			t.Errorf("highlightTerms(%q, %q) = %q, want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureSearchIndex(t *testing.T) {
+	c := openSQLiteTestClient(t)
+	if !c.fts {
+		t.Skip("this SQLite build has no FTS5")
+	}
+	migratedTestClient(t, c)
+	createTestVideo(t, c)
+
+	indexed := func() int {
+		t.Helper()
+		var n int
+		if err := c.queryRow("SELECT COUNT(*) FROM videos_fts").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// a row only a rebuild would remove
+	if _, err := c.exec("INSERT INTO videos_fts (title, description, video_id) VALUES ('stray', '', 'none')"); err != nil {
+		t.Fatal(err)
+	}
+	migratedTestClient(t, c)
+	if n := indexed(); n != 2 {
+		t.Errorf("index has %d rows after starting again, want it left alone with 2", n)
+	}
+
+	// a build without FTS5 started meanwhile
+	withoutFTS := c
+	withoutFTS.fts = false
+	if err := withoutFTS.ensureSearchIndex(); err != nil {
+		t.Fatal(err)
+	}
+	migratedTestClient(t, c)
+	if n := indexed(); n != 1 {
+		t.Errorf("index has %d rows after a build without FTS5 ran, want it rebuilt with 1", n)
+	}
+
+	if _, err := c.exec("DROP TABLE videos_fts"); err != nil {
+		t.Fatal(err)
+	}
+	migratedTestClient(t, c)
+	if n := indexed(); n != 1 {
+		t.Errorf("index has %d rows after being dropped, want it rebuilt with 1", n)
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
+var ErrInvalidTransition = errors.New("invalid video status transition")
+
+var videoStatusTransitions = map[VideoStatus][]VideoStatus{
+	VideoStatusDraft:      {VideoStatusUploading, VideoStatusProcessing},
+	VideoStatusUploading:  {VideoStatusUploading, VideoStatusProcessing, VideoStatusFailed},
+	VideoStatusProcessing: {VideoStatusReady, VideoStatusFailed},
+	VideoStatusReady:      {VideoStatusUploading, VideoStatusProcessing},
+	VideoStatusFailed:     {VideoStatusUploading, VideoStatusProcessing},
+}
+
+// timestamp column recording when a video last entered each status
+var videoStatusColumns = map[VideoStatus]string{
+	VideoStatusUploading:  "uploading_at",
+	VideoStatusProcessing: "processing_at",
+	VideoStatusReady:      "ready_at",
+	VideoStatusFailed:     "failed_at",
+}
+
+func CanTransition(from, to VideoStatus) bool {
+	for _, s := range videoStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SetVideoStatus moves a video to status `to`. reason is stored as the
+// failure reason when `to` is failed and ignored otherwise. It returns an
+// error wrapping ErrInvalidTransition if the video can't move to `to` from
+// its current status.
+func (c Client) SetVideoStatus(id uuid.UUID, to VideoStatus, reason string) error {
+	var from VideoStatus
+	err := c.queryRow("SELECT status FROM videos WHERE id = ?", id).Scan(&from)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("video %s: %w", id, ErrNotFound)
+		}
+		return err
+	}
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	var failureReason *string
+	if to == VideoStatusFailed {
+		failureReason = &reason
+	}
+
+	query := fmt.Sprintf(`
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		%s = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND status = ?
+	`, videoStatusColumns[to])
+	res, err := c.exec(query, to, failureReason, id, from)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: status changed concurrently", ErrInvalidTransition)
+	}
+	return nil
+}
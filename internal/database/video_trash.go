@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash. It reports false if the video
+// doesn't exist or is already trashed.
+func (c Client) TrashVideo(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	res, err := c.exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// RestoreVideo takes a video out of the trash. It reports false if the video
+// wasn't in the trash.
+func (c Client) RestoreVideo(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = NULL,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	res, err := c.exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// GetVideosTrashedBefore returns the videos that went to the trash before t.
+func (c Client) GetVideosTrashedBefore(t time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	ORDER BY deleted_at
+	`
+
+	rows, err := c.query(query, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// PurgeVideo deletes a video for good and queues job, which deletes its
+// objects, in the same transaction: either the row is gone and its objects
+// are queued for deletion, or neither happened and the video can be purged
+// again.
+func (c Client) PurgeVideo(id uuid.UUID, job CreateJobParams) (Job, error) {
+	var jobID uuid.UUID
+	err := c.inTx(func(tx *sql.Tx) error {
+		var err error
+		jobID, err = c.insertJob(tx, job)
+		if err != nil {
+			return err
+		}
+		return c.deleteVideo(tx, id)
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(jobID)
+}
@@ -3,17 +3,37 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Version goes up with every change to the video.
+	Version int `json:"version"`
+	// The URLs are resolved from the object refs below before a video is
+	// sent to a client; they are never stored.
+	ThumbnailURL          *string        `json:"thumbnail_url"`
+	VideoURL              *string        `json:"video_url"`
+	ManifestURL           *string        `json:"manifest_url"`
+	ThumbnailSpriteURL    *string        `json:"thumbnail_sprite_url"`
+	Thumbnails            map[int]string `json:"thumbnails"`
+	ThumbnailObject       *ObjectRef     `json:"-"`
+	VideoObject           *ObjectRef     `json:"-"`
+	ManifestObject        *ObjectRef     `json:"-"`
+	ThumbnailSpriteObject *ObjectRef     `json:"-"`
+	ThumbnailObjects      ThumbnailRefs  `json:"-"`
+	Status                VideoStatus    `json:"status"`
+	FailureReason         *string        `json:"failure_reason"`
+	UploadingAt           *time.Time     `json:"uploading_at"`
+	ProcessingAt          *time.Time     `json:"processing_at"`
+	ReadyAt               *time.Time     `json:"ready_at"`
+	FailedAt              *time.Time     `json:"failed_at"`
+	DeletedAt             *time.Time     `json:"deleted_at"`
 	CreateVideoParams
 }
 
@@ -23,23 +43,66 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
+		version,
 		title,
 		description,
-		thumbnail_url,
-		video_url,
-		user_id
+		thumbnail_object,
+		video_object,
+		manifest_object,
+		thumbnail_sprite_object,
+		thumbnail_objects,
+		status,
+		failure_reason,
+		uploading_at,
+		processing_at,
+		ready_at,
+		failed_at,
+		deleted_at,
+		user_id`
+
+// videoScanDest returns the Scan destinations of videoColumns.
+func videoScanDest(video *Video) []any {
+	return []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Version,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailObject,
+		&video.VideoObject,
+		&video.ManifestObject,
+		&video.ThumbnailSpriteObject,
+		&video.ThumbnailObjects,
+		&video.Status,
+		&video.FailureReason,
+		&video.UploadingAt,
+		&video.ProcessingAt,
+		&video.ReadyAt,
+		&video.FailedAt,
+		&video.DeletedAt,
+		&video.UserID,
+	}
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(videoScanDest(&video)...)
+	return video, err
+}
+
+// GetAllVideos returns every video of every user, including trashed ones.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +110,14 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +129,17 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		status,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	err := c.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(c.rebind(query), id, params.Title, params.Description, VideoStatusDraft, params.UserID)
+		if err != nil {
+			return err
+		}
+		return c.indexVideo(tx, id, params.Title, params.Description)
+	})
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,34 +147,32 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns ErrNotFound if id doesn't exist or is in the trash.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, "deleted_at IS NULL")
+}
+
+// GetTrashedVideo returns ErrNotFound unless id is in the trash.
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, "deleted_at IS NOT NULL")
+}
+
+// GetVideoIncludingTrashed is GetVideo for callers that keep working on
+// videos in the trash, since they may still be restored.
+func (c Client) GetVideoIncludingTrashed(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, "1 = 1")
+}
+
+func (c Client) getVideo(id uuid.UUID, condition string) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
-	`
+	WHERE id = ? AND ` + condition
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
+			return Video{}, ErrNotFound
 		}
 		return Video{}, err
 	}
@@ -121,35 +180,84 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// UpdateVideo saves the editable fields of video. Status is changed only
+// through SetVideoStatus. It changes nothing and returns ErrNotFound if the
+// video is gone, or an error wrapping ErrConflict if the stored video is no
+// longer at video.Version.
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		thumbnail_object = ?,
+		video_object = ?,
+		manifest_object = ?,
+		thumbnail_sprite_object = ?,
+		thumbnail_objects = ?,
+		user_id = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND version = ?
 	`
 
-	_, err := c.db.Exec(
-		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
-	)
-	return err
+	return c.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			c.rebind(query),
+			video.Title,
+			video.Description,
+			video.ThumbnailObject,
+			video.VideoObject,
+			video.ManifestObject,
+			video.ThumbnailSpriteObject,
+			video.ThumbnailObjects,
+			video.UserID,
+			video.ID,
+			video.Version,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			var exists bool
+			err := tx.QueryRow(c.rebind("SELECT EXISTS (SELECT 1 FROM videos WHERE id = ?)"), video.ID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return ErrNotFound
+			}
+			return fmt.Errorf("video %s at version %d: %w", video.ID, video.Version, ErrConflict)
+		}
+		return c.indexVideo(tx, video.ID, video.Title, video.Description)
+	})
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		return c.deleteVideo(tx, id)
+	})
+}
+
+// deleteVideo deletes a video along with its media info and search index
+// entry.
+func (c Client) deleteVideo(tx *sql.Tx, id uuid.UUID) error {
+	_, err := tx.Exec(c.rebind("DELETE FROM media_info WHERE video_id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if err := c.unindexVideo(tx, id); err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = tx.Exec(c.rebind(query), id)
 	return err
 }
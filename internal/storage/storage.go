@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// URL returns the public (unsigned) URL for key.
+	URL(key string) string
+}
+
+type Backend string
+
+const (
+	BackendS3     Backend = "s3"
+	BackendLocal  Backend = "local"
+	BackendMemory Backend = "memory"
+)
+
+func ParseBackend(s string) (Backend, error) {
+	switch Backend(s) {
+	case "":
+		return BackendS3, nil
+	case BackendS3, BackendLocal, BackendMemory:
+		return Backend(s), nil
+	}
+	return "", fmt.Errorf("unknown storage backend %q", s)
+}
+
+// KeyFromURL reverses store.URL. It reports false for URLs that don't point
+// into store.
+func KeyFromURL(store BlobStore, url string) (string, bool) {
+	prefix := store.URL("")
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	key := strings.TrimPrefix(url, prefix)
+	return key, key != ""
+}
@@ -0,0 +1,36 @@
+package storage
+
+import "testing"
+
+func TestKeyFromURL(t *testing.T) {
+	stores := []struct {
+		name  string
+		store BlobStore
+	}{
+		{"s3", NewS3Store(nil, "tubely", "https://d111111abcdef8.cloudfront.net/")},
+		{"local", NewLocalStore(t.TempDir(), "http://localhost:8091/assets")},
+		{"memory", NewMemoryStore("http://localhost:8091/memory")},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			for _, key := range []string{"thumb.jpeg", "landscape/abc.mp4", "hls/abc/360p/segment_0000.ts"} {
+				u := s.store.URL(key)
+				if got, ok := KeyFromURL(s.store, u); !ok || got != key {
+					t.Errorf("KeyFromURL(%q) = %q, %v; want %q", u, got, ok, key)
+				}
+			}
+
+			prefix := s.store.URL("")
+			for _, u := range []string{
+				prefix,
+				prefix[:len(prefix)-1],
+				"https://tubely.s3.us-east-2.amazonaws.com/thumb.jpeg",
+				prefix[:len(prefix)-1] + ".example.com/thumb.jpeg",
+			} {
+				if got, ok := KeyFromURL(s.store, u); ok {
+					t.Errorf("KeyFromURL(%q) = %q, want no key", u, got)
+				}
+			}
+		})
+	}
+}
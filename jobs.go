@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobTypeProcessVideo = "process_video"
+
+	jobMaxAttempts  = 5
+	jobPollInterval = 2 * time.Second
+	jobBaseBackoff  = 30 * time.Second
+	jobMaxBackoff   = 30 * time.Minute
+
+	// A worker holds a lease on the job it runs and renews it well before it
+	// expires. Jobs whose lease expired are requeued: the instance running
+	// them died.
+	jobLeaseDuration      = 5 * time.Minute
+	jobLeaseRenewInterval = time.Minute
+)
+
+type processVideoPayload struct {
+	SourcePath string `json:"source_path"`
+}
+
+type jobHandler func(ctx context.Context, job database.Job) error
+
+// errPermanent marks job failures that retrying can't fix.
+var errPermanent = errors.New("permanent failure")
+
+func (cfg *apiConfig) jobHandlers() map[string]jobHandler {
+	return map[string]jobHandler{
+		jobTypeProcessVideo:  cfg.runProcessVideoJob,
+		jobTypeDeleteObjects: cfg.runDeleteObjectsJob,
+	}
+}
+
+// enqueueProcessVideo queues srcPath for processing. The job takes ownership
+// of the file and removes it once it's done with it.
+func (cfg *apiConfig) enqueueProcessVideo(videoID uuid.UUID, srcPath string) (database.Job, error) {
+	payload, err := json.Marshal(processVideoPayload{SourcePath: srcPath})
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		Type:        jobTypeProcessVideo,
+		VideoID:     videoID,
+		Payload:     string(payload),
+		MaxAttempts: jobMaxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	cfg.wakeWorkers()
+	return job, nil
+}
+
+func (cfg *apiConfig) runProcessVideoJob(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errPermanent, err)
+	}
+
+	// a trashed video may still be restored, so finish processing it
+	video, err := cfg.db.GetVideoIncludingTrashed(job.VideoID)
+	if errors.Is(err, database.ErrNotFound) {
+		os.Remove(payload.SourcePath)
+		return fmt.Errorf("%w: video %s no longer exists", errPermanent, job.VideoID)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(payload.SourcePath); err != nil {
+		return fmt.Errorf("%w: source file: %v", errPermanent, err)
+	}
+
+	_, err = cfg.publishVideo(ctx, video, payload.SourcePath)
+	if err != nil {
+		return err
+	}
+	os.Remove(payload.SourcePath)
+	return nil
+}
+
+// failVideo marks a video failed, logging instead of returning errors since
+// callers are already handling another failure.
+func (cfg *apiConfig) failVideo(videoID uuid.UUID, reason string) {
+	err := cfg.db.SetVideoStatus(videoID, database.VideoStatusFailed, reason)
+	if err != nil {
+		log.Printf("Couldn't mark video %s failed: %v", videoID, err)
+	}
+}
+
+func (cfg *apiConfig) wakeWorkers() {
+	select {
+	case cfg.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// startWorkers runs n workers until ctx is cancelled.
+func (cfg *apiConfig) startWorkers(ctx context.Context, n int) error {
+	if err := cfg.requeueExpiredJobs(); err != nil {
+		return err
+	}
+	go cfg.jobRequeuer(ctx)
+
+	handlers := cfg.jobHandlers()
+	for i := 0; i < n; i++ {
+		go cfg.worker(ctx, handlers)
+	}
+	return nil
+}
+
+// jobRequeuer requeues jobs of instances that died while running them, for
+// as long as ctx lives.
+func (cfg *apiConfig) jobRequeuer(ctx context.Context) {
+	ticker := time.NewTicker(jobLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.requeueExpiredJobs(); err != nil {
+				log.Printf("Couldn't requeue interrupted jobs: %v", err)
+			}
+		}
+	}
+}
+
+func (cfg *apiConfig) requeueExpiredJobs() error {
+	requeued, err := cfg.db.RequeueExpiredJobs(time.Now())
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted jobs", requeued)
+		cfg.wakeWorkers()
+	}
+	return nil
+}
+
+func (cfg *apiConfig) worker(ctx context.Context, handlers map[string]jobHandler) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain the queue before sleeping again
+		for ctx.Err() == nil {
+			now := time.Now()
+			job, err := cfg.db.ClaimJob(now, now.Add(jobLeaseDuration))
+			if err != nil {
+				log.Printf("Couldn't claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			cfg.runJob(ctx, handlers, *job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.jobWake:
+		}
+	}
+}
+
+func (cfg *apiConfig) runJob(ctx context.Context, handlers map[string]jobHandler, job database.Job) {
+	handler, ok := handlers[job.Type]
+	if !ok {
+		err := cfg.db.FailJob(job.ID, fmt.Sprintf("unknown job type %q", job.Type))
+		if err != nil {
+			log.Printf("Couldn't fail job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	leaseDone := make(chan struct{})
+	go func() {
+		defer close(leaseDone)
+		cfg.holdJobLease(jobCtx, cancel, job.ID)
+	}()
+	err := handler(jobCtx, job)
+	cancel(nil)
+	<-leaseDone
+	if errors.Is(context.Cause(jobCtx), errLeaseLost) {
+		// the job is back in the queue, it's not ours to record
+		log.Printf("Job %s (%s) stopped: %v", job.ID, job.Type, errLeaseLost)
+		return
+	}
+	if err == nil {
+		if err := cfg.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't complete job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Job %s (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+	if errors.Is(err, errPermanent) || job.Attempts >= job.MaxAttempts {
+		if job.Type == jobTypeProcessVideo {
+			cfg.failVideo(job.VideoID, err.Error())
+		}
+		err = cfg.db.FailJob(job.ID, err.Error())
+	} else {
+		err = cfg.db.RetryJob(job.ID, time.Now().Add(jobBackoff(job.Attempts)), err.Error())
+	}
+	if err != nil {
+		log.Printf("Couldn't record failure of job %s: %v", job.ID, err)
+	}
+}
+
+// errLeaseLost cancels the context of a job whose lease was lost.
+var errLeaseLost = errors.New("lost the lease on the job")
+
+// holdJobLease renews the lease on a running job until ctx is done. If the
+// lease is lost anyway, e.g. because the database was unreachable for
+// longer than jobLeaseDuration, the job may already run elsewhere, so
+// cancel stops it.
+func (cfg *apiConfig) holdJobLease(ctx context.Context, cancel context.CancelCauseFunc, jobID uuid.UUID) {
+	ticker := time.NewTicker(jobLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := cfg.db.ExtendJobLease(jobID, time.Now().Add(jobLeaseDuration))
+		if errors.Is(err, database.ErrNotFound) {
+			cancel(errLeaseLost)
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't renew the lease on job %s: %v", jobID, err)
+		}
+	}
+}
+
+// jobBackoff doubles the delay after every failed attempt.
+func jobBackoff(attempts int) time.Duration {
+	backoff := jobBaseBackoff
+	for i := 1; i < attempts && backoff < jobMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, jobMaxBackoff)
+}
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get job", err)
+		return
+	}
+
+	// jobs of videos that are gone or in the trash aren't shown either
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if errors.Is(err, database.ErrNotFound) || err == nil && video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
@@ -21,6 +24,19 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+// respondWithDBError responds to an error from the database package with 404
+// for missing rows, 409 for conflicting writes and 500 for anything else.
+func respondWithDBError(w http.ResponseWriter, msg string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, database.ErrConflict), errors.Is(err, database.ErrDuplicateEmail):
+		code = http.StatusConflict
+	}
+	respondWithError(w, code, msg, err)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// accessTokenTTL is how long an access token from a refresh can be used.
+// Tokens from logging in last for loginAccessTokenTTL, since the web app
+// doesn't refresh them.
+const (
+	accessTokenTTL      = time.Hour
+	loginAccessTokenTTL = 30 * 24 * time.Hour
+)
+
+// jwtKeyRefreshInterval is how often keys are reloaded from the database,
+// to pick up rotations by other instances.
+const jwtKeyRefreshInterval = time.Minute
+
+// jwtKeyReloadThrottle is how soon after the last load a token signed with
+// an unknown key can cause another.
+const jwtKeyReloadThrottle = 10 * time.Second
+
+// jwtKeys holds the keys access tokens are signed and validated with. With
+// HS256 that is JWT_SECRET. With RS256 and EdDSA, keys are generated and
+// kept in the database, encrypted with encryptionKey, so all instances share
+// them: the newest signs new tokens, and retired keys keep validating for
+// gracePeriod after being replaced, until the tokens they signed have
+// expired.
+type jwtKeys struct {
+	db        database.Client
+	algorithm string
+	// secret validates tokens from before switching away from HS256.
+	secret        string
+	encryptionKey []byte
+	// rotationInterval is how old the signing key gets before it is
+	// replaced; 0 means never.
+	rotationInterval time.Duration
+	gracePeriod      time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	set      atomic.Pointer[auth.KeySet]
+}
+
+func (k *jwtKeys) keySet() *auth.KeySet {
+	return k.set.Load()
+}
+
+// load reads the keys from the database, generating a signing key if there
+// is none for the algorithm or it is due for rotation.
+func (k *jwtKeys) load() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.algorithm == auth.AlgorithmHS256 {
+		k.set.Store(auth.NewKeySet(auth.NewHMACKey(k.secret)))
+		k.loadedAt = time.Now()
+		return nil
+	}
+
+	now := time.Now()
+	if err := k.db.DeleteSigningKeysRetiredBefore(now.Add(-k.gracePeriod)); err != nil {
+		return fmt.Errorf("couldn't delete retired signing keys: %w", err)
+	}
+	stored, err := k.db.ListSigningKeys(now.Add(-k.gracePeriod))
+	if err != nil {
+		return fmt.Errorf("couldn't list signing keys: %w", err)
+	}
+	if len(stored) == 0 || stored[0].RetiredAt != nil || stored[0].Algorithm != k.algorithm ||
+		k.rotationInterval > 0 && now.Sub(stored[0].CreatedAt) >= k.rotationInterval {
+		current, err := k.generate()
+		if err != nil {
+			return err
+		}
+		stored = append([]database.SigningKey{current}, stored...)
+	}
+
+	var keys []auth.Key
+	for _, s := range stored {
+		privateKey, err := k.openPrivateKey(s)
+		if err != nil {
+			return fmt.Errorf("couldn't decrypt signing key %s: %w", s.ID, err)
+		}
+		key, err := auth.ParseKey(s.ID, s.Algorithm, privateKey)
+		if err != nil {
+			return fmt.Errorf("couldn't parse signing key %s: %w", s.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	if k.secret != "" {
+		keys = append(keys, auth.NewHMACKey(k.secret))
+	}
+	k.set.Store(auth.NewKeySet(keys[0], keys[1:]...))
+	k.loadedAt = now
+	return nil
+}
+
+// openPrivateKey returns the PEM private key of a stored key. Keys stored
+// unencrypted by older versions are encrypted in place on the way.
+func (k *jwtKeys) openPrivateKey(stored database.SigningKey) (string, error) {
+	if auth.PrivateKeySealed(stored.PrivateKey) {
+		return auth.OpenPrivateKey(stored.ID, stored.PrivateKey, k.encryptionKey)
+	}
+	sealed, err := auth.SealPrivateKey(stored.ID, stored.PrivateKey, k.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	err = k.db.SetSigningKeyPrivateKey(stored.ID, sealed)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return "", fmt.Errorf("couldn't encrypt stored key: %w", err)
+	}
+	log.Printf("Encrypted stored signing key %s", stored.ID)
+	return stored.PrivateKey, nil
+}
+
+func (k *jwtKeys) generate() (database.SigningKey, error) {
+	key, privateKey, err := auth.GenerateKey(k.algorithm)
+	if err != nil {
+		return database.SigningKey{}, fmt.Errorf("couldn't generate signing key: %w", err)
+	}
+	sealed, err := auth.SealPrivateKey(key.ID, privateKey, k.encryptionKey)
+	if err != nil {
+		return database.SigningKey{}, fmt.Errorf("couldn't encrypt signing key: %w", err)
+	}
+	stored, err := k.db.CreateSigningKey(database.CreateSigningKeyParams{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: sealed,
+	})
+	if err != nil {
+		return database.SigningKey{}, fmt.Errorf("couldn't store signing key: %w", err)
+	}
+	log.Printf("Signing access tokens with new %s key %s", key.Algorithm, key.ID)
+	return stored, nil
+}
+
+// rotate replaces the signing key right away.
+func (k *jwtKeys) rotate() error {
+	if k.algorithm == auth.AlgorithmHS256 {
+		return errors.New("HS256 keys can only be rotated by changing JWT_SECRET")
+	}
+	k.mu.Lock()
+	_, err := k.generate()
+	k.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return k.load()
+}
+
+// validate validates an access token and returns its user and session.
+func (k *jwtKeys) validate(token string) (userID uuid.UUID, sessionID string, err error) {
+	err = k.validateWith(func(keys *auth.KeySet) error {
+		userID, sessionID, err = auth.ValidateJWT(token, keys)
+		return err
+	})
+	return userID, sessionID, err
+}
+
+// validateHLS validates an HLS token and returns the directory it grants
+// access to.
+func (k *jwtKeys) validateHLS(token string) (dir string, err error) {
+	err = k.validateWith(func(keys *auth.KeySet) error {
+		dir, err = auth.ValidateHLSToken(token, keys)
+		return err
+	})
+	return dir, err
+}
+
+// validateWith validates a token with the keys. A token signed with a key it
+// doesn't know yet, likely rotated in by another instance, makes it reload
+// the keys once before giving up.
+func (k *jwtKeys) validateWith(validate func(keys *auth.KeySet) error) error {
+	err := validate(k.keySet())
+	if !errors.Is(err, auth.ErrUnknownKey) {
+		return err
+	}
+
+	k.mu.Lock()
+	recent := time.Since(k.loadedAt) < jwtKeyReloadThrottle
+	k.mu.Unlock()
+	if recent {
+		return err
+	}
+	if err := k.load(); err != nil {
+		log.Printf("Couldn't reload JWT keys: %v", err)
+	}
+	return validate(k.keySet())
+}
+
+func (cfg *apiConfig) startJWTKeyRefresher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jwtKeyRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := cfg.jwtKeys.load(); err != nil {
+				log.Printf("Couldn't refresh JWT keys: %v", err)
+			}
+		}
+	}()
+}
+
+// handlerJWKS publishes the public keys access tokens are signed with, so
+// other services can validate them.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Keys []auth.JWK `json:"keys"`
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, response{Keys: cfg.jwtKeys.keySet().JWKS()})
+}
+
+// handlerJWTKeyRotate replaces the signing key right away, as
+// JWT_KEY_ROTATION_INTERVAL would.
+func (cfg *apiConfig) handlerJWTKeyRotate(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Key rotation can only be triggered in dev environment."))
+		return
+	}
+
+	if err := cfg.jwtKeys.rotate(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate signing key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestJWTKeysEncrypted(t *testing.T) {
+	cfg, _ := newUploadTestConfig(t)
+	encryptionKey := []byte(strings.Repeat("k", 32))
+	newKeys := func(encryptionKey []byte) *jwtKeys {
+		return &jwtKeys{db: cfg.db, algorithm: auth.AlgorithmEdDSA, encryptionKey: encryptionKey, gracePeriod: time.Hour}
+	}
+	storedKeys := func() []database.SigningKey {
+		t.Helper()
+		stored, err := cfg.db.ListSigningKeys(time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	first := newKeys(encryptionKey)
+	if err := first.load(); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedKeys()
+	if len(stored) != 1 || !auth.PrivateKeySealed(stored[0].PrivateKey) || strings.Contains(stored[0].PrivateKey, "PRIVATE KEY") {
+		t.Fatalf("stored keys = %+v, want one encrypted key", stored)
+	}
+
+	// another instance with the same encryption key validates the tokens
+	token, err := auth.MakeJWT(uuid.New(), "session", first.keySet(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newKeys(encryptionKey)
+	if err := second.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := second.validate(token); err != nil {
+		t.Errorf("token validated by another instance: %v", err)
+	}
+	if err := newKeys([]byte(strings.Repeat("x", 32))).load(); err == nil {
+		t.Error("loading with another encryption key succeeded")
+	}
+
+	// a key stored unencrypted by an older version gets encrypted
+	_, privateKey, err := auth.GenerateKey(auth.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetSigningKeyPrivateKey(stored[0].ID, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := newKeys(encryptionKey).load(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedKeys(); !auth.PrivateKeySealed(stored[0].PrivateKey) {
+		t.Errorf("unencrypted key left as it was after loading")
+	}
+}
@@ -2,21 +2,25 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	jwtKeys          *jwtKeys
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -24,28 +28,96 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client 			   *s3.Client	
+	uploadsRoot      string
+	storageBackend   storage.Backend
+	videoStore       storage.BlobStore
+	assetStore       storage.BlobStore
+	jobWake          chan struct{}
+
+	// urlMode is how URLs to objects in S3 are formed in responses.
+	urlMode urlMode
+
+	// thumbnailAt is where thumbnails are taken from; 0 means the first
+	// scene change.
+	thumbnailAt         time.Duration
+	thumbnailCandidates int
+
+	// trashPeriod is how long deleted videos can be restored.
+	trashPeriod time.Duration
+
+	// Orphaned objects older than gcGracePeriod are removed every
+	// gcInterval, or only logged when gcDryRun is set.
+	gcInterval    time.Duration
+	gcGracePeriod time.Duration
+	gcDryRun      bool
 }
 
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_PATH is the older name, from when only SQLite was supported
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	db, err := database.OpenClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if !db.FullTextSearch() {
+		log.Print("This build of SQLite has no FTS5, so search falls back to LIKE; build with -tags sqlite_fts5 for the search index")
+	}
+
+	jwtAlgorithm := os.Getenv("JWT_ALGORITHM")
+	switch jwtAlgorithm {
+	case "":
+		jwtAlgorithm = auth.AlgorithmHS256
+	case auth.AlgorithmHS256, auth.AlgorithmRS256, auth.AlgorithmEdDSA:
+	default:
+		log.Fatal("JWT_ALGORITHM must be HS256, RS256 or EdDSA")
+	}
 
+	// With RS256 and EdDSA, JWT_SECRET only validates the HS256 tokens
+	// issued before switching.
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
+	if jwtSecret == "" && jwtAlgorithm == auth.AlgorithmHS256 {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
 
+	// RS256 and EdDSA keys are kept in the database, encrypted with this key
+	var jwtKeyEncryptionKey []byte
+	if jwtAlgorithm != auth.AlgorithmHS256 {
+		jwtKeyEncryptionKey, err = auth.ParseEncryptionKey(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+		if err != nil {
+			log.Fatalf("JWT_KEY_ENCRYPTION_KEY must be 32 random bytes in base64, e.g. from `openssl rand -base64 32`: %v", err)
+		}
+	}
+
+	var jwtKeyRotationInterval time.Duration
+	if v := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); v != "" {
+		jwtKeyRotationInterval, err = time.ParseDuration(v)
+		if err != nil || jwtKeyRotationInterval < 0 {
+			log.Fatal("JWT_KEY_ROTATION_INTERVAL must be a non-negative duration such as 720h")
+		}
+	}
+
+	// Retired keys must outlive the tokens they signed, including those
+	// signed by instances that hadn't picked up the new key yet, or
+	// rotating them would log out everyone who logged in before.
+	minJWTKeyGracePeriod := loginAccessTokenTTL + jwtKeyRefreshInterval
+	jwtKeyGracePeriod := minJWTKeyGracePeriod
+	if v := os.Getenv("JWT_KEY_GRACE_PERIOD"); v != "" {
+		jwtKeyGracePeriod, err = time.ParseDuration(v)
+		if err != nil || jwtKeyGracePeriod < minJWTKeyGracePeriod {
+			log.Fatalf("JWT_KEY_GRACE_PERIOD must be a duration of at least %s, the lifetime of a login token plus a key refresh", minJWTKeyGracePeriod)
+		}
+	}
+
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
@@ -61,43 +133,158 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = "./uploads"
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
-	}	
+	workerCount := 2
+	if v := os.Getenv("WORKER_COUNT"); v != "" {
+		workerCount, err = strconv.Atoi(v)
+		if err != nil || workerCount < 1 {
+			log.Fatal("WORKER_COUNT must be a positive integer")
+		}
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	var thumbnailAt time.Duration
+	if v := os.Getenv("THUMBNAIL_TIMESTAMP"); v != "" {
+		thumbnailAt, err = time.ParseDuration(v)
+		if err != nil || thumbnailAt < 0 {
+			log.Fatal("THUMBNAIL_TIMESTAMP must be a non-negative duration such as 5s")
+		}
+	}
+
+	thumbnailCandidates := 0
+	if v := os.Getenv("THUMBNAIL_CANDIDATES"); v != "" {
+		thumbnailCandidates, err = strconv.Atoi(v)
+		if err != nil || thumbnailCandidates < 0 {
+			log.Fatal("THUMBNAIL_CANDIDATES must be a non-negative integer")
+		}
+	}
+
+	trashPeriod := 7 * 24 * time.Hour
+	if v := os.Getenv("TRASH_PERIOD"); v != "" {
+		trashPeriod, err = time.ParseDuration(v)
+		if err != nil || trashPeriod < 0 {
+			log.Fatal("TRASH_PERIOD must be a non-negative duration such as 168h")
+		}
+	}
+
+	gcInterval := 24 * time.Hour
+	if v := os.Getenv("GC_INTERVAL"); v != "" {
+		gcInterval, err = time.ParseDuration(v)
+		if err != nil || gcInterval < 0 {
+			log.Fatal("GC_INTERVAL must be a non-negative duration such as 24h")
+		}
+	}
+
+	gcGracePeriod := 24 * time.Hour
+	if v := os.Getenv("GC_GRACE_PERIOD"); v != "" {
+		gcGracePeriod, err = time.ParseDuration(v)
+		if err != nil || gcGracePeriod < 0 {
+			log.Fatal("GC_GRACE_PERIOD must be a non-negative duration such as 24h")
+		}
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	// deleting from the stores has to be asked for
+	gcDryRun := true
+	if v := os.Getenv("GC_DRY_RUN"); v != "" {
+		gcDryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("GC_DRY_RUN must be true or false")
+		}
+	}
+
+	storageBackend, err := storage.ParseBackend(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_BACKEND: %v", err)
+	}
+
+	videoURLMode, err := parseURLMode(os.Getenv("VIDEO_URL_MODE"))
 	if err != nil {
-		log.Fatalf("Failing loading config file: %v", err)
+		log.Fatalf("Invalid VIDEO_URL_MODE: %v", err)
+	}
+
+	var s3Bucket, s3Region, s3CfDistribution string
+	var videoStore storage.BlobStore
+	switch storageBackend {
+	case storage.BackendS3:
+		s3Bucket = os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+
+		s3Region = os.Getenv("S3_REGION")
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+
+		s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatalf("Failing loading config file: %v", err)
+		}
+		s3Client := s3.NewFromConfig(awsCfg)
+		videoStore = storage.NewS3Store(s3Client, s3Bucket, "https://"+s3CfDistribution)
+	case storage.BackendLocal:
+		videoStore = storage.NewLocalStore(assetsRoot, fmt.Sprintf("http://localhost:%s/assets", port))
+	case storage.BackendMemory:
+		videoStore = storage.NewMemoryStore(fmt.Sprintf("http://localhost:%s/blobs", port))
+	}
+	assetStore := storage.NewLocalStore(assetsRoot, fmt.Sprintf("http://localhost:%s/assets", port))
+
+	signingKeys := &jwtKeys{
+		db:               db,
+		algorithm:        jwtAlgorithm,
+		secret:           jwtSecret,
+		encryptionKey:    jwtKeyEncryptionKey,
+		rotationInterval: jwtKeyRotationInterval,
+		gracePeriod:      jwtKeyGracePeriod,
 	}
-	s3Client := s3.NewFromConfig(awsCfg)
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		jwtKeys:          signingKeys,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
-		port:             port,		
-		s3Client:					s3Client,			
+		port:             port,
+		uploadsRoot:      uploadsRoot,
+		storageBackend:   storageBackend,
+		videoStore:       videoStore,
+		assetStore:       assetStore,
+		jobWake:          make(chan struct{}, 1),
+		urlMode:          videoURLMode,
+
+		thumbnailAt:         thumbnailAt,
+		thumbnailCandidates: thumbnailCandidates,
+		trashPeriod:         trashPeriod,
+		gcInterval:          gcInterval,
+		gcGracePeriod:       gcGracePeriod,
+		gcDryRun:            gcDryRun,
+	}
+
+	// migrating needs the stores, to convert the URLs older versions stored
+	err = db.Migrate(database.MigrateOptions{LegacyObjectRef: cfg.legacyObjectRef})
+	if err != nil {
+		log.Fatalf("Couldn't migrate database: %v", err)
+	}
+
+	err = cfg.jwtKeys.load()
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %v", err)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -105,6 +292,22 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = cfg.ensureUploadsDir()
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	err = cfg.startWorkers(context.Background(), workerCount)
+	if err != nil {
+		log.Fatalf("Couldn't start workers: %v", err)
+	}
+	cfg.startTrashPurger(context.Background())
+	cfg.startUploadSweeper(context.Background())
+	cfg.startJWTKeyRefresher(context.Background())
+	if cfg.gcInterval > 0 {
+		cfg.startGarbageCollector(context.Background())
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
@@ -112,21 +315,45 @@ func main() {
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	if storageBackend == storage.BackendMemory {
+		blobsHandler := http.StripPrefix("/blobs", storage.Handler(videoStore))
+		mux.Handle("/blobs/", noCacheMiddleware(blobsHandler))
+	}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.Handle("GET /api/sessions", cfg.withAuth(authLogin, cfg.handlerSessionsList))
+	mux.Handle("DELETE /api/sessions", cfg.withAuth(authLogin, cfg.handlerSessionsDeleteAll))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.withAuth(authLogin, cfg.handlerSessionDelete))
+	mux.Handle("POST /api/api_keys", cfg.withAuth(authLogin, cfg.handlerAPIKeyCreate))
+	mux.Handle("GET /api/api_keys", cfg.withAuth(authLogin, cfg.handlerAPIKeysList))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.withAuth(authLogin, cfg.handlerAPIKeyDelete))
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.Handle("POST /api/videos", cfg.withAuth(authUpload, cfg.handlerVideoMetaCreate))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.withAuth(authUpload, cfg.handlerUploadThumbnail))
+	mux.Handle("POST /api/videos/{videoID}/thumbnail_candidates/{index}", cfg.withAuth(authUpload, cfg.handlerThumbnailPick))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.withAuth(authUpload, cfg.handlerUploadVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/sessions", cfg.withAuth(authUpload, cfg.handlerUploadSessionCreate))
+	mux.Handle("HEAD /api/uploads/{uploadID}", cfg.withAuth(authUpload, cfg.handlerUploadSessionHead))
+	mux.Handle("PATCH /api/uploads/{uploadID}", cfg.withAuth(authUpload, cfg.handlerUploadSessionPatch))
+	mux.Handle("POST /api/uploads/{uploadID}/finalize", cfg.withAuth(authUpload, cfg.handlerUploadSessionFinalize))
+	mux.HandleFunc("GET /api/hls/{token}/{path...}", cfg.handlerHLSPlaylist)
+	mux.Handle("GET /api/jobs/{jobID}", cfg.withAuth(authReadOrUpload, cfg.handlerJobGet))
+	mux.Handle("GET /api/videos", cfg.withAuth(authRead, cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/search", cfg.withAuth(authRead, cfg.handlerVideosSearch))
+	mux.Handle("GET /api/videos/{videoID}", cfg.withAuth(authRead, cfg.handlerVideoGet))
 	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.withAuth(authUpload, cfg.handlerVideoUpdate))
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.withAuth(authDelete, cfg.handlerVideoMetaDelete))
+	mux.Handle("POST /api/videos/{videoID}/restore", cfg.withAuth(authDelete, cfg.handlerVideoRestore))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /admin/gc", cfg.handlerGC)
+	mux.HandleFunc("POST /admin/jwt_keys/rotate", cfg.handlerJWTKeyRotate)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type ffprobeStream struct {
+	Index        int    `json:"index"`
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	// SampleAspectRatio is "num:den", or "0:1"/"" when unknown.
+	SampleAspectRatio string            `json:"sample_aspect_ratio"`
+	SampleRate        string            `json:"sample_rate"`
+	Channels          int               `json:"channels"`
+	Tags              map[string]string `json:"tags"`
+	Disposition       struct {
+		// AttachedPic marks cover art: a single picture stored as a video
+		// stream, usually mjpeg or png.
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+	SideDataList []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  ffprobeFormat   `json:"format"`
+}
+
+func runFFprobe(filePath string) (ffprobeOutput, error) {
+	cmd := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		filePath,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return ffprobeOutput{}, fmt.Errorf("ffprobe: %w: %s", err, lastLine(stderr.Bytes()))
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return ffprobeOutput{}, err
+	}
+	return probe, nil
+}
+
+// firstStream returns the first stream of the given codec type, or nil.
+// Cover art isn't counted as a video stream.
+func (p ffprobeOutput) firstStream(codecType string) *ffprobeStream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == codecType && p.Streams[i].Disposition.AttachedPic == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// rotation returns the clockwise display rotation of s in degrees, from
+// either the legacy "rotate" tag or the display matrix side data.
+func (s ffprobeStream) rotation() int {
+	degrees := 0.0
+	if tag, ok := s.Tags["rotate"]; ok {
+		degrees, _ = strconv.ParseFloat(tag, 64)
+	} else {
+		for _, sd := range s.SideDataList {
+			if sd.SideDataType == "Display Matrix" {
+				// the display matrix rotates counter-clockwise
+				degrees = -sd.Rotation
+				break
+			}
+		}
+	}
+	r := int(math.Round(degrees)) % 360
+	if r < 0 {
+		r += 360
+	}
+	return r
+}
+
+// displaySize returns the size s is shown at: stored width scaled by the
+// sample aspect ratio, swapped when rotated by a quarter turn.
+func (s ffprobeStream) displaySize() (int, int) {
+	width, height := s.Width, s.Height
+	if num, den, ok := strings.Cut(s.SampleAspectRatio, ":"); ok {
+		n, d := parseInt64(num), parseInt64(den)
+		if n > 0 && d > 0 {
+			width = int(math.Round(float64(width) * float64(n) / float64(d)))
+		}
+	}
+	if r := s.rotation(); r == 90 || r == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// probeMediaInfo describes the file at filePath. VideoID is left unset.
+func probeMediaInfo(filePath string) (database.MediaInfo, error) {
+	probe, err := runFFprobe(filePath)
+	if err != nil {
+		return database.MediaInfo{}, err
+	}
+
+	video := probe.firstStream("video")
+	if video == nil {
+		return database.MediaInfo{}, fmt.Errorf("no video stream found")
+	}
+
+	info := database.MediaInfo{
+		Container:  probe.Format.FormatName,
+		Size:       parseInt64(probe.Format.Size),
+		Duration:   parseFloat(probe.Format.Duration),
+		Bitrate:    parseInt64(probe.Format.BitRate),
+		VideoCodec: video.CodecName,
+		Width:      video.Width,
+		Height:     video.Height,
+		FrameRate:  parseFrameRate(video.AvgFrameRate),
+		Rotation:   video.rotation(),
+	}
+
+	if audio := probe.firstStream("audio"); audio != nil {
+		sampleRate := int(parseInt64(audio.SampleRate))
+		info.AudioCodec = &audio.CodecName
+		info.AudioChannels = &audio.Channels
+		info.AudioSampleRate = &sampleRate
+	}
+
+	return info, nil
+}
+
+// ffprobe reports most numbers as strings, and "N/A" when it doesn't know
+// them. Unknown values become 0.
+
+func parseInt64(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// parseFrameRate parses rationals such as "30000/1001".
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return math.Round(parseFloat(num)/d*1000) / 1000
+}
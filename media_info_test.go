@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestDisplaySize(t *testing.T) {
+	type sideData = struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	}
+	tests := []struct {
+		name                  string
+		stream                ffprobeStream
+		wantWidth, wantHeight int
+	}{
+		{
+			name:       "plain landscape",
+			stream:     ffprobeStream{Width: 1920, Height: 1080},
+			wantWidth:  1920,
+			wantHeight: 1080,
+		},
+		{
+			name: "phone portrait via display matrix",
+			stream: ffprobeStream{
+				Width:        1920,
+				Height:       1080,
+				SideDataList: []sideData{{SideDataType: "Display Matrix", Rotation: -90}},
+			},
+			wantWidth:  1080,
+			wantHeight: 1920,
+		},
+		{
+			name: "portrait via rotate tag",
+			stream: ffprobeStream{
+				Width:  1920,
+				Height: 1080,
+				Tags:   map[string]string{"rotate": "270"},
+			},
+			wantWidth:  1080,
+			wantHeight: 1920,
+		},
+		{
+			name: "upside down",
+			stream: ffprobeStream{
+				Width:        1920,
+				Height:       1080,
+				SideDataList: []sideData{{SideDataType: "Display Matrix", Rotation: 180}},
+			},
+			wantWidth:  1920,
+			wantHeight: 1080,
+		},
+		{
+			name:       "anamorphic DVD",
+			stream:     ffprobeStream{Width: 720, Height: 480, SampleAspectRatio: "32:27"},
+			wantWidth:  853,
+			wantHeight: 480,
+		},
+		{
+			name:       "unknown sample aspect ratio",
+			stream:     ffprobeStream{Width: 640, Height: 480, SampleAspectRatio: "0:1"},
+			wantWidth:  640,
+			wantHeight: 480,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := tt.stream.displaySize()
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("displaySize() = %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
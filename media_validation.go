@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Reasons reported in mediaRejection.Reason.
+const (
+	rejectNotMP4            = "not_mp4"
+	rejectNotAnImage        = "not_an_image"
+	rejectUnreadable        = "unreadable"
+	rejectNoVideoStream     = "no_video_stream"
+	rejectUnsupportedCodec  = "unsupported_codec"
+	rejectUndecodableStream = "undecodable_video"
+)
+
+// mp4Brands are the ftyp major brands accepted as MP4. QuickTime ("qt  ")
+// shares the container layout but isn't MP4.
+var mp4Brands = []string{"isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "mmp4", "MSNV", "dash"}
+
+var (
+	allowedVideoCodecs = []string{"h264", "hevc", "av1", "vp9", "mpeg4"}
+	allowedAudioCodecs = []string{"aac", "mp3", "opus", "ac3", "eac3", "alac", "flac"}
+)
+
+// mediaRejection describes why an upload was refused. It is sent as the body
+// of the 415 response.
+type mediaRejection struct {
+	Reason   string   `json:"reason"`
+	Detail   string   `json:"detail"`
+	Detected string   `json:"detected,omitempty"`
+	Allowed  []string `json:"allowed,omitempty"`
+}
+
+func (e *mediaRejection) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+}
+
+func respondWithMediaRejection(w http.ResponseWriter, rejection *mediaRejection) {
+	log.Println(rejection)
+	type errorResponse struct {
+		Error string `json:"error"`
+		*mediaRejection
+	}
+	respondWithJSON(w, http.StatusUnsupportedMediaType, errorResponse{
+		Error:          "Unsupported media type",
+		mediaRejection: rejection,
+	})
+}
+
+// sniffMP4 checks the magic bytes at the start of a file for an MP4 ftyp box.
+func sniffMP4(r io.Reader) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return &mediaRejection{
+			Reason:   rejectNotMP4,
+			Detail:   "file doesn't start with an MP4 ftyp box",
+			Detected: http.DetectContentType(head),
+			Allowed:  []string{"video/mp4"},
+		}
+	}
+	brand := string(head[8:12])
+	if !slices.Contains(mp4Brands, brand) {
+		return &mediaRejection{
+			Reason:   rejectNotMP4,
+			Detail:   fmt.Sprintf("unsupported ftyp brand %q", brand),
+			Detected: strings.TrimSpace(brand),
+			Allowed:  mp4Brands,
+		}
+	}
+	return nil
+}
+
+// sniffMP4File runs sniffMP4 on the file at path.
+func sniffMP4File(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sniffMP4(f)
+}
+
+// validateMP4 uses ffprobe to check that the file at path is an MP4 with a
+// decodable video stream and only allowed codecs. It returns a
+// *mediaRejection for files we refuse and a plain error when the check
+// itself couldn't run.
+func validateMP4(path string) error {
+	probe, err := runFFprobe(path)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &mediaRejection{Reason: rejectUnreadable, Detail: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	video, err := checkProbe(probe)
+	if err != nil {
+		return err
+	}
+
+	// ffprobe only reads headers; decode a frame to be sure the stream works
+	cmd := exec.Command(
+		"ffmpeg",
+		"-v", "error",
+		"-i", path,
+		"-map", fmt.Sprintf("0:%d", video.Index),
+		"-frames:v", "1",
+		"-f", "null",
+		"-",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return &mediaRejection{Reason: rejectUndecodableStream, Detail: lastLine(out)}
+		}
+		return err
+	}
+	return nil
+}
+
+// checkProbe checks what ffprobe found in a file and returns its video
+// stream. Cover art (mjpeg or png stored as a video stream) is ignored, and
+// only the first real video stream has to use an allowed codec.
+func checkProbe(probe ffprobeOutput) (*ffprobeStream, error) {
+	if !slices.Contains(strings.Split(probe.Format.FormatName, ","), "mp4") {
+		return nil, &mediaRejection{
+			Reason:   rejectNotMP4,
+			Detail:   "container isn't MP4",
+			Detected: probe.Format.FormatName,
+			Allowed:  []string{"mp4"},
+		}
+	}
+
+	video := probe.firstStream("video")
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return nil, &mediaRejection{Reason: rejectNoVideoStream, Detail: "file has no video stream"}
+	}
+	if !slices.Contains(allowedVideoCodecs, video.CodecName) {
+		return nil, &mediaRejection{
+			Reason:   rejectUnsupportedCodec,
+			Detail:   "unsupported video codec",
+			Detected: video.CodecName,
+			Allowed:  allowedVideoCodecs,
+		}
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" && !slices.Contains(allowedAudioCodecs, stream.CodecName) {
+			return nil, &mediaRejection{
+				Reason:   rejectUnsupportedCodec,
+				Detail:   "unsupported audio codec",
+				Detected: stream.CodecName,
+				Allowed:  allowedAudioCodecs,
+			}
+		}
+	}
+	return video, nil
+}
+
+// checkMP4File runs both the magic-byte sniffing and the ffprobe validation.
+func checkMP4File(path string) error {
+	if err := sniffMP4File(path); err != nil {
+		return err
+	}
+	return validateMP4(path)
+}
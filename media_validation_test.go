@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestCheckProbeCoverArt(t *testing.T) {
+	data, err := os.ReadFile("testdata/ffprobe_cover_art.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a fresh copy for every case, so edits don't leak into the next
+	probe := func() ffprobeOutput {
+		var probe ffprobeOutput
+		if err := json.Unmarshal(data, &probe); err != nil {
+			t.Fatal(err)
+		}
+		return probe
+	}
+
+	tests := []struct {
+		name       string
+		edit       func(p *ffprobeOutput)
+		wantReason string
+	}{
+		{
+			name: "cover art after the video",
+			edit: func(p *ffprobeOutput) {},
+		},
+		{
+			name: "cover art before the video",
+			edit: func(p *ffprobeOutput) { slices.Reverse(p.Streams) },
+		},
+		{
+			name:       "only cover art",
+			edit:       func(p *ffprobeOutput) { p.Streams = p.Streams[1:] },
+			wantReason: rejectNoVideoStream,
+		},
+		{
+			name:       "unsupported video codec",
+			edit:       func(p *ffprobeOutput) { p.Streams[0].CodecName = "mpeg2video" },
+			wantReason: rejectUnsupportedCodec,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := probe()
+			tt.edit(&p)
+			video, err := checkProbe(p)
+			if tt.wantReason != "" {
+				var rejection *mediaRejection
+				if !errors.As(err, &rejection) || rejection.Reason != tt.wantReason {
+					t.Fatalf("checkProbe() = %v, want a %s rejection", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkProbe() = %v", err)
+			}
+			if video.Index != 0 || video.CodecName != "h264" {
+				t.Errorf("checkProbe() picked stream %d (%s), want the h264 stream 0", video.Index, video.CodecName)
+			}
+		})
+	}
+}
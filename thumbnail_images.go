@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// thumbnailWidths are the widths of the stored thumbnail variants, smallest
+// first.
+var thumbnailWidths = []int{160, 320, 640, 1280}
+
+const (
+	// thumbnailMaxPixels caps the size of images we are willing to decode.
+	thumbnailMaxPixels = 50_000_000
+
+	thumbnailJPEGQuality = 85
+)
+
+var errNotAnImage = errors.New("not a JPEG, PNG or WebP image")
+
+// decodeThumbnail decodes a JPEG, PNG or WebP image and turns it upright by
+// its EXIF orientation. Only the pixels are kept, so EXIF and other metadata
+// never make it into the stored variants.
+//
+// The size is checked from the header first, so an oversized image is
+// refused before it is read into memory, let alone decoded.
+func decodeThumbnail(r io.ReadSeeker) (image.Image, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotAnImage, err)
+	}
+	switch format {
+	case "jpeg", "png", "webp":
+	default:
+		return nil, errNotAnImage
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", config.Width, config.Height)
+	}
+
+	// the EXIF orientation may be anywhere in the file, e.g. at the end of
+	// a WebP
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotAnImage, err)
+	}
+	return applyOrientation(img, exifOrientation(data)), nil
+}
+
+// thumbnailVariantWidths picks the variants that don't upscale an image
+// width pixels wide. Images narrower than the smallest variant are kept at
+// their own width.
+func thumbnailVariantWidths(width int) []int {
+	widths := []int{}
+	for _, w := range thumbnailWidths {
+		if w <= width {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		widths = append(widths, width)
+	}
+	return widths
+}
+
+// resizeToWidth scales img to width, keeping its aspect ratio. Transparent
+// areas become white since variants are stored as JPEG.
+func resizeToWidth(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	height := max(1, src.Dy()*width/src.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+// publishThumbnail stores every variant of img as <key>/<width>.jpeg under a
+// fresh random key.
+func (cfg *apiConfig) publishThumbnail(ctx context.Context, img image.Image) (database.ThumbnailRefs, error) {
+	prefix := randomAssetKey()
+	thumbnails := database.ThumbnailRefs{}
+	for _, width := range thumbnailVariantWidths(img.Bounds().Dx()) {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, resizeToWidth(img, width), &jpeg.Options{Quality: thumbnailJPEGQuality})
+		if err != nil {
+			return nil, err
+		}
+
+		key := path.Join(prefix, fmt.Sprintf("%d.jpeg", width))
+		if err := cfg.assetStore.Put(ctx, key, &buf, "image/jpeg"); err != nil {
+			return nil, err
+		}
+		thumbnails[width] = *cfg.assetRef(key)
+	}
+	return thumbnails, nil
+}
+
+// setThumbnails points video at a new set of variants. ThumbnailObject is
+// kept pointing at the largest one for clients that predate the variants.
+func setThumbnails(video *database.Video, thumbnails database.ThumbnailRefs) {
+	largest := 0
+	for width := range thumbnails {
+		largest = max(largest, width)
+	}
+	thumbnail := thumbnails[largest]
+	video.ThumbnailObject = &thumbnail
+	video.ThumbnailObjects = thumbnails
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDecodeThumbnailRefusesHugeImagesFromTheHeader(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// claim 100000x100000 in the IHDR chunk and drop the pixel data, which
+	// decoding would need
+	data := encoded.Bytes()[:33]
+	binary.BigEndian.PutUint32(data[16:20], 100_000)
+	binary.BigEndian.PutUint32(data[20:24], 100_000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := decodeThumbnail(bytes.NewReader(data))
+	if err == nil || errors.Is(err, errNotAnImage) || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("decodeThumbnail of a 100000x100000 PNG = %v, want too large", err)
+	}
+}
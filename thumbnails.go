@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// thumbnailSceneThreshold is the ffmpeg scene score (0-1) a frame needs
+	// to count as a scene change.
+	thumbnailSceneThreshold = 0.3
+
+	// Sprite sheets are a single row of tiles this wide, so tile i starts at
+	// x = i*thumbnailSpriteTileWidth.
+	thumbnailSpriteTileWidth = 320
+)
+
+// randomAssetKey returns a fresh key so replaced assets are never served
+// from a stale cache.
+func randomAssetKey() string {
+	// generate random 32 bytes file path
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	// encode to base64
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// putThumbnail stores an image as-is under a fresh random key and returns its
+// ref.
+func (cfg *apiConfig) putThumbnail(ctx context.Context, body io.Reader, mediaType string) (*database.ObjectRef, error) {
+	thumbFile := fmt.Sprintf("%v.%s", randomAssetKey(), mediaType[len("image/"):])
+
+	err := cfg.assetStore.Put(ctx, thumbFile, body, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.assetRef(thumbFile), nil
+}
+
+// generateThumbnails fills in the thumbnail of a video that has none and, if
+// enabled, a sprite sheet of candidate thumbnails. Failures are only logged:
+// a missing thumbnail shouldn't fail the upload.
+func (cfg *apiConfig) generateThumbnails(ctx context.Context, video *database.Video, srcPath string) {
+	if video.ThumbnailObject == nil {
+		thumbnails, err := cfg.publishExtractedThumbnail(ctx, srcPath)
+		if err != nil {
+			log.Printf("Couldn't extract thumbnail of video %s: %v", video.ID, err)
+		} else {
+			setThumbnails(video, thumbnails)
+		}
+	}
+
+	if cfg.thumbnailCandidates > 0 {
+		sprite, err := cfg.publishThumbnailSprite(ctx, srcPath)
+		if err != nil {
+			log.Printf("Couldn't extract thumbnail candidates of video %s: %v", video.ID, err)
+		} else {
+			video.ThumbnailSpriteObject = sprite
+		}
+	}
+}
+
+func (cfg *apiConfig) publishExtractedThumbnail(ctx context.Context, srcPath string) (database.ThumbnailRefs, error) {
+	out := srcPath + ".jpg"
+	defer os.Remove(out)
+
+	if err := extractThumbnail(srcPath, out, cfg.thumbnailAt); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := decodeThumbnail(f)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.publishThumbnail(ctx, img)
+}
+
+// publishThumbnailSprite stores the sprite sheet as-is: it is only a picker,
+// picked frames go through publishThumbnail.
+func (cfg *apiConfig) publishThumbnailSprite(ctx context.Context, srcPath string) (*database.ObjectRef, error) {
+	out := srcPath + ".sprite.jpg"
+	defer os.Remove(out)
+
+	if err := extractThumbnailSprite(srcPath, out, cfg.thumbnailCandidates); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return cfg.putThumbnail(ctx, f, "image/jpeg")
+}
+
+// extractThumbnail writes a single frame of srcPath to out. With at == 0 it
+// picks the first scene change, falling back to the first frame for videos
+// without one (or when at is past the end).
+func extractThumbnail(srcPath, out string, at time.Duration) error {
+	var args []string
+	if at > 0 {
+		args = []string{"-ss", fmt.Sprintf("%.3f", at.Seconds()), "-i", srcPath}
+	} else {
+		args = []string{"-i", srcPath, "-vf", fmt.Sprintf("select='gt(scene,%g)'", thumbnailSceneThreshold)}
+	}
+	if err := runFrameExtraction(args, out); err != nil {
+		return err
+	}
+	if ok, err := nonEmptyFile(out); err != nil || ok {
+		return err
+	}
+	return runFrameExtraction([]string{"-i", srcPath}, out)
+}
+
+// extractThumbnailSprite writes n frames spread evenly over srcPath to out as
+// a single row of tiles.
+func extractThumbnailSprite(srcPath, out string, n int) error {
+	duration, err := getVideoDuration(srcPath)
+	if err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("fps=%f,scale=%d:-2,tile=%dx1", float64(n)/duration, thumbnailSpriteTileWidth, n)
+	if err := runFrameExtraction([]string{"-i", srcPath, "-vf", filter}, out); err != nil {
+		return err
+	}
+	if ok, err := nonEmptyFile(out); err != nil || !ok {
+		return fmt.Errorf("no frames extracted")
+	}
+	return nil
+}
+
+func runFrameExtraction(inputArgs []string, out string) error {
+	args := append([]string{"-y", "-v", "error"}, inputArgs...)
+	args = append(args, "-frames:v", "1", "-q:v", "3", out)
+	cmd := exec.Command("ffmpeg", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLine(output))
+	}
+	return nil
+}
+
+func nonEmptyFile(path string) (bool, error) {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return stat.Size() > 0, nil
+}
+
+func getVideoDuration(filePath string) (float64, error) {
+	cmd := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %w", err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %v", duration)
+	}
+	return duration, nil
+}
+
+// handlerThumbnailPick makes tile {index} of the video's sprite sheet its
+// thumbnail.
+func (cfg *apiConfig) handlerThumbnailPick(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid candidate index", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.ThumbnailSpriteObject == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail candidates", nil)
+		return
+	}
+	spriteStore, ok := cfg.storeFor(*video.ThumbnailSpriteObject)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail candidates", nil)
+		return
+	}
+
+	body, _, err := spriteStore.Get(r.Context(), video.ThumbnailSpriteObject.Key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+	defer body.Close()
+
+	sprite, err := jpeg.Decode(body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode thumbnail candidates", err)
+		return
+	}
+	bounds := sprite.Bounds()
+	tile := image.Rect(index*thumbnailSpriteTileWidth, 0, (index+1)*thumbnailSpriteTileWidth, bounds.Dy()).Add(bounds.Min)
+	if !tile.In(bounds) {
+		respondWithError(w, http.StatusBadRequest, "No such thumbnail candidate", nil)
+		return
+	}
+	frame := sprite.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(tile)
+
+	thumbnails, err := cfg.publishThumbnail(r.Context(), frame)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not save the thumbnail", err)
+		return
+	}
+	spriteRef := *video.ThumbnailSpriteObject
+	video, err = cfg.updateVideo(videoID, func(video *database.Video) error {
+		// the tile is only meaningful in the sprite sheet it was cut from
+		if video.ThumbnailSpriteObject == nil || *video.ThumbnailSpriteObject != spriteRef {
+			return fmt.Errorf("thumbnail candidates were replaced: %w", database.ErrConflict)
+		}
+		setThumbnails(video, thumbnails)
+		return nil
+	})
+	if err != nil {
+		respondWithDBError(w, "Could not update the video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
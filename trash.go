@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Deleted videos sit in the trash for cfg.trashPeriod, during which they can
+// be restored. After that the row is removed and a delete_objects job, which
+// is retried like any other job, removes everything the video stored.
+
+const (
+	jobTypeDeleteObjects = "delete_objects"
+
+	trashPurgeInterval = 10 * time.Minute
+)
+
+type deleteObjectsPayload struct {
+	Objects []database.ObjectRef `json:"objects"`
+	// Prefixes are directories, such as HLS renditions, that are deleted
+	// with everything in them.
+	Prefixes []database.ObjectRef `json:"prefixes"`
+}
+
+// videoObjects lists everything stored for video.
+func videoObjects(video database.Video) deleteObjectsPayload {
+	var payload deleteObjectsPayload
+	refs := []*database.ObjectRef{video.VideoObject, video.ThumbnailObject, video.ThumbnailSpriteObject}
+	for _, ref := range video.ThumbnailObjects {
+		refs = append(refs, &ref)
+	}
+	seen := map[database.ObjectRef]bool{}
+	for _, ref := range refs {
+		if ref != nil && !seen[*ref] {
+			seen[*ref] = true
+			payload.Objects = append(payload.Objects, *ref)
+		}
+	}
+
+	if video.ManifestObject != nil {
+		prefix := *video.ManifestObject
+		prefix.Key = path.Dir(prefix.Key) + "/"
+		payload.Prefixes = append(payload.Prefixes, prefix)
+	}
+	return payload
+}
+
+// purgeVideo deletes a video for good and queues the deletion of its
+// objects.
+func (cfg *apiConfig) purgeVideo(video database.Video) error {
+	payload, err := json.Marshal(videoObjects(video))
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.PurgeVideo(video.ID, database.CreateJobParams{
+		Type:        jobTypeDeleteObjects,
+		VideoID:     video.ID,
+		Payload:     string(payload),
+		MaxAttempts: jobMaxAttempts,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.wakeWorkers()
+	return nil
+}
+
+func (cfg *apiConfig) runDeleteObjectsJob(ctx context.Context, job database.Job) error {
+	var payload deleteObjectsPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errPermanent, err)
+	}
+
+	objects := payload.Objects
+	for _, prefix := range payload.Prefixes {
+		store, ok := cfg.storeFor(prefix)
+		if !ok {
+			return fmt.Errorf("%w: no store configured for %s bucket %q", errPermanent, prefix.Backend, prefix.Bucket)
+		}
+		listed, err := store.List(ctx, prefix.Key)
+		if err != nil {
+			return fmt.Errorf("could not list %s: %w", prefix.Key, err)
+		}
+		for _, object := range listed {
+			ref := prefix
+			ref.Key = object.Key
+			objects = append(objects, ref)
+		}
+	}
+
+	// deleting is idempotent, so a retry simply starts over
+	var errs []error
+	for _, ref := range objects {
+		store, ok := cfg.storeFor(ref)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: no store configured for %s bucket %q", errPermanent, ref.Backend, ref.Bucket))
+			continue
+		}
+		if err := deleteObject(ctx, store, ref.Key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func deleteObject(ctx context.Context, store storage.BlobStore, key string) error {
+	err := store.Delete(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("could not delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// purgeTrash purges every video that has been in the trash for longer than
+// cfg.trashPeriod.
+func (cfg *apiConfig) purgeTrash() {
+	videos, err := cfg.db.GetVideosTrashedBefore(time.Now().Add(-cfg.trashPeriod))
+	if err != nil {
+		log.Printf("Couldn't list trashed videos: %v", err)
+		return
+	}
+	for _, video := range videos {
+		if err := cfg.purgeVideo(video); err != nil {
+			log.Printf("Couldn't purge video %s: %v", video.ID, err)
+		}
+	}
+}
+
+// startTrashPurger purges the trash periodically until ctx is cancelled.
+func (cfg *apiConfig) startTrashPurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			cfg.purgeTrash()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := principalFrom(r.Context()).UserID
+
+	video, err := cfg.db.GetTrashedVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) || err == nil && video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video not found in the trash", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	restored, err := cfg.db.RestoreVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	if !restored {
+		respondWithError(w, http.StatusNotFound, "Video not found in the trash", nil)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// The videos table stores where objects live (database.ObjectRef), never
+// URLs. URLs are resolved when a video is sent to a client, so moving to a
+// different CDN or switching to presigned URLs doesn't touch stored rows.
+
+// urlMode selects how objects in S3 are linked to.
+type urlMode string
+
+const (
+	urlModeCDN       urlMode = "cdn"       // https://<S3_CF_DISTRO>/<key>
+	urlModeDirect    urlMode = "direct"    // https://<bucket>.s3.<region>.amazonaws.com/<key>
+	urlModePresigned urlMode = "presigned" // presigned GET, valid for presignedURLTTL
+
+	presignedURLTTL = 5 * time.Minute
+)
+
+func parseURLMode(s string) (urlMode, error) {
+	switch urlMode(s) {
+	case "":
+		return urlModeCDN, nil
+	case urlModeCDN, urlModeDirect, urlModePresigned:
+		return urlMode(s), nil
+	}
+	return "", fmt.Errorf("unknown URL mode %q", s)
+}
+
+// videoRef returns the ref of key in the video store.
+func (cfg *apiConfig) videoRef(key string) *database.ObjectRef {
+	return &database.ObjectRef{Backend: string(cfg.storageBackend), Bucket: cfg.s3Bucket, Key: key}
+}
+
+// assetRef returns the ref of key in the asset store.
+func (cfg *apiConfig) assetRef(key string) *database.ObjectRef {
+	return &database.ObjectRef{Backend: string(storage.BackendLocal), Key: key}
+}
+
+// storeFor returns the configured store holding ref. It reports false for
+// objects in a backend or bucket this server isn't configured for.
+func (cfg *apiConfig) storeFor(ref database.ObjectRef) (storage.BlobStore, bool) {
+	switch {
+	case ref.Backend == string(cfg.storageBackend) && ref.Bucket == cfg.s3Bucket:
+		return cfg.videoStore, true
+	case ref.Backend == string(storage.BackendLocal):
+		return cfg.assetStore, true
+	}
+	return nil, false
+}
+
+func (cfg *apiConfig) resolveURL(ctx context.Context, ref *database.ObjectRef) (*string, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	store, ok := cfg.storeFor(*ref)
+	if !ok {
+		// e.g. a bucket we have since moved away from
+		log.Printf("No store configured for %s object %q in bucket %q", ref.Backend, ref.Key, ref.Bucket)
+		return nil, nil
+	}
+
+	var u string
+	switch {
+	case ref.Backend != string(storage.BackendS3) || cfg.urlMode == urlModeCDN:
+		u = store.URL(ref.Key)
+	case cfg.urlMode == urlModeDirect:
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", ref.Bucket, cfg.s3Region, ref.Key)
+	case cfg.urlMode == urlModePresigned:
+		signed, err := store.PresignGet(ctx, ref.Key, presignedURLTTL)
+		if err != nil {
+			return nil, err
+		}
+		u = signed
+	}
+	return &u, nil
+}
+
+// resolveVideoURLs fills in the URL fields of video from its object refs.
+func (cfg *apiConfig) resolveVideoURLs(ctx context.Context, video database.Video) (database.Video, error) {
+	targets := []struct {
+		ref *database.ObjectRef
+		url **string
+	}{
+		{video.ThumbnailObject, &video.ThumbnailURL},
+		{video.VideoObject, &video.VideoURL},
+		{video.ManifestObject, &video.ManifestURL},
+		{video.ThumbnailSpriteObject, &video.ThumbnailSpriteURL},
+	}
+	for _, t := range targets {
+		u, err := cfg.resolveURL(ctx, t.ref)
+		if err != nil {
+			return video, err
+		}
+		*t.url = u
+	}
+	if ref := video.ManifestObject; ref != nil && video.ManifestURL != nil &&
+		ref.Backend == string(storage.BackendS3) && cfg.urlMode == urlModePresigned {
+		// the segments need signing too, see hlsManifestURL
+		u, err := cfg.hlsManifestURL(*ref)
+		if err != nil {
+			return video, err
+		}
+		video.ManifestURL = &u
+	}
+
+	video.Thumbnails = nil
+	if video.ThumbnailObjects != nil {
+		video.Thumbnails = map[int]string{}
+		for width, ref := range video.ThumbnailObjects {
+			u, err := cfg.resolveURL(ctx, &ref)
+			if err != nil {
+				return video, err
+			}
+			if u != nil {
+				video.Thumbnails[width] = *u
+			}
+		}
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) respondWithVideo(w http.ResponseWriter, r *http.Request, code int, video database.Video) {
+	video, err := cfg.resolveVideoURLs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
+	}
+	respondWithJSON(w, code, video)
+}
+
+// legacyObjectRef works out which object a stored URL pointed at.
+func (cfg *apiConfig) legacyObjectRef(rawURL string) (*database.ObjectRef, bool) {
+	if key, ok := storage.KeyFromURL(cfg.videoStore, rawURL); ok {
+		return cfg.videoRef(key), true
+	}
+	if key, ok := storage.KeyFromURL(cfg.assetStore, rawURL); ok {
+		return cfg.assetRef(key), true
+	}
+
+	// "bucket,key", the format once used for presigned URLs
+	if bucket, key, ok := strings.Cut(rawURL, ","); ok && bucket != "" && key != "" {
+		return &database.ObjectRef{Backend: string(storage.BackendS3), Bucket: bucket, Key: key}, true
+	}
+
+	// https://<bucket>.s3.<region>.amazonaws.com/<key>
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false
+	}
+	if bucket, rest, ok := strings.Cut(u.Host, ".s3."); ok && strings.HasSuffix(rest, ".amazonaws.com") {
+		key := strings.TrimPrefix(u.Path, "/")
+		if key != "" {
+			return &database.ObjectRef{Backend: string(storage.BackendS3), Bucket: bucket, Key: key}, true
+		}
+	}
+	return nil, false
+}
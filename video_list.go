@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	defaultVideoPageSize = 20
+	maxVideoPageSize     = 100
+)
+
+// videoCursor is what next_cursor encodes. It remembers the ordering so a
+// cursor can't be replayed against a different one.
+type videoCursor struct {
+	After      database.VideoCursor `json:"after"`
+	Sort       database.VideoSort   `json:"sort"`
+	Descending bool                 `json:"desc"`
+}
+
+func encodeVideoCursor(cursor videoCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeVideoCursor(s string) (videoCursor, error) {
+	var cursor videoCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// parseListVideosQuery reads the paging, sorting and filtering parameters of
+// GET /api/videos. Errors are meant for the client.
+func parseListVideosQuery(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		Sort:  database.VideoSortCreated,
+		Limit: defaultVideoPageSize,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideoPageSize)
+		}
+		params.Limit = limit
+	}
+
+	if v := query.Get("sort"); v != "" {
+		params.Sort = database.VideoSort(v)
+		if !params.Sort.Valid() {
+			return params, fmt.Errorf("sort must be created, updated, title or duration")
+		}
+	}
+	// newest, longest first; titles alphabetically
+	params.Descending = params.Sort != database.VideoSortTitle
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		return params, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeVideoCursor(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Sort != params.Sort || cursor.Descending != params.Descending {
+			return params, fmt.Errorf("cursor was issued for a different sort order")
+		}
+		if !cursor.After.Valid(params.Sort) {
+			return params, fmt.Errorf("invalid cursor")
+		}
+		params.After = &cursor.After
+	}
+
+	for _, f := range []struct {
+		name string
+		dest **bool
+	}{
+		{"has_video", &params.HasVideo},
+		{"has_thumbnail", &params.HasThumbnail},
+	} {
+		if v := query.Get(f.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return params, fmt.Errorf("%s must be true or false", f.name)
+			}
+			*f.dest = &b
+		}
+	}
+
+	if v := query.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status := database.VideoStatus(strings.TrimSpace(s))
+			switch status {
+			case database.VideoStatusDraft, database.VideoStatusUploading, database.VideoStatusProcessing, database.VideoStatusReady, database.VideoStatusFailed:
+			default:
+				return params, fmt.Errorf("unknown status %q", status)
+			}
+			params.Statuses = append(params.Statuses, status)
+		}
+	}
+
+	if v := query.Get("orientation"); v != "" {
+		params.Orientation = database.Orientation(v)
+		if !params.Orientation.Valid() {
+			return params, fmt.Errorf("orientation must be landscape, portrait or square")
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"created_after", &params.CreatedAfter},
+		{"created_before", &params.CreatedBefore},
+	} {
+		if v := query.Get(f.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return params, fmt.Errorf("%s must be an RFC 3339 time such as 2024-01-02T15:04:05Z", f.name)
+			}
+			*f.dest = &t
+		}
+	}
+
+	return params, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxVideoUpdateAttempts bounds how often updateVideo starts over when the
+// video keeps changing under it.
+const maxVideoUpdateAttempts = 5
+
+// updateVideo reads a video, including one in the trash, applies change to
+// it and saves it. If the video changed in between, it starts over from a
+// fresh read, so change must be safe to run more than once. Errors from
+// change are returned as they are; after too many conflicts the error wraps
+// database.ErrConflict, and database.ErrNotFound if the video is deleted.
+func (cfg *apiConfig) updateVideo(id uuid.UUID, change func(*database.Video) error) (database.Video, error) {
+	var err error
+	for range maxVideoUpdateAttempts {
+		var video database.Video
+		video, err = cfg.db.GetVideoIncludingTrashed(id)
+		if err != nil {
+			return video, err
+		}
+		if err := change(&video); err != nil {
+			return video, err
+		}
+		err = cfg.db.UpdateVideo(video)
+		if err == nil {
+			return cfg.db.GetVideoIncludingTrashed(id)
+		}
+		if !errors.Is(err, database.ErrConflict) {
+			return video, err
+		}
+	}
+	return database.Video{}, err
+}